@@ -6,12 +6,17 @@ import (
 	"os"
 	"path/filepath"
 
+	"dg/internal/check/git"
 	"dg/internal/cron"
 	"dg/internal/run"
 	"dg/internal/version"
 )
 
 func main() {
+	// dg re-executes itself as GIT_ASKPASS to hand credentials to git
+	if git.ServeAskpass(os.Args) {
+		return
+	}
 	if len(os.Args) < 2 {
 		helpCmd()
 		return
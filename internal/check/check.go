@@ -0,0 +1,183 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Result 保存一次检测的结果
+type Result struct {
+	Triggered bool
+	Logs      []string
+	// State 为本次观察到的值，脚本成功执行后写入 state.yml 的 watchs.<name>
+	// 为 nil 时保持原有记录不变
+	State map[string]string
+	// Vars 为传给脚本的环境变量（名称见 EnvName）
+	Vars map[string]string
+	// Actions 为触发后、执行脚本前按顺序运行的内置动作，任一失败则不再执行脚本
+	Actions []Action
+	// Warnings 为按 on_error: warn 容忍的检测失败，运行以 warning 结束并记录到 state.yml
+	Warnings []string
+}
+
+// Action 是检测触发后执行的内置动作，如同步 Git 工作区
+type Action struct {
+	// Desc 描述动作，用于日志
+	Desc string
+	Run  func(ctx context.Context) error
+}
+
+// 对比基准，供各监听类型的 compare 配置使用
+const (
+	// CompareLocal 与本机现状对比（本地仓库、本地镜像等）
+	CompareLocal = "local"
+	// CompareDeployed 与 state.yml 中记录的上次成功部署的值对比
+	CompareDeployed = "deployed"
+)
+
+// ParseCompare 校验 compare 配置，空值默认为 CompareLocal
+func ParseCompare(s string) (string, error) {
+	switch s {
+	case "":
+		return CompareLocal, nil
+	case CompareLocal, CompareDeployed:
+		return s, nil
+	}
+	return "", fmt.Errorf("compare must be %s or %s, got %q", CompareLocal, CompareDeployed, s)
+}
+
+// 检测失败时的处理方式，供各监听类型的 on_error 配置使用
+const (
+	// OnErrorFail 中止本次运行，不执行脚本
+	OnErrorFail = "fail"
+	// OnErrorSkip 记录日志后继续，运行结果仍为 success
+	OnErrorSkip = "skip"
+	// OnErrorWarn 记录日志后继续，运行结果为 warning，退出码为 2
+	OnErrorWarn = "warn"
+)
+
+// ParseOnError 校验 on_error 配置，空值时默认为 fail
+func ParseOnError(s string) (string, error) {
+	switch s {
+	case "":
+		return OnErrorFail, nil
+	case OnErrorFail, OnErrorSkip, OnErrorWarn:
+		return s, nil
+	}
+	return "", fmt.Errorf("on_error must be %s, %s or %s, got %q", OnErrorFail, OnErrorSkip, OnErrorWarn, s)
+}
+
+// EnvName 由各部分拼出脚本环境变量名：加 DG_ 前缀，转大写，非字母数字替换为 _
+// 例如 EnvName("docker", "myorg/api") 为 DG_DOCKER_MYORG_API
+func EnvName(parts ...string) string {
+	var b strings.Builder
+	b.WriteString("DG")
+	for _, p := range parts {
+		b.WriteByte('_')
+		for _, r := range strings.ToUpper(p) {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return b.String()
+}
+
+// Secret 返回机密配置的值：优先取 value，其次取环境变量 env，
+// 最后读取文件 file 并去除首尾空白；三者都未配置时返回空串
+func Secret(value, env, file string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if env != "" {
+		v := os.Getenv(env)
+		if v == "" {
+			return "", fmt.Errorf("environment variable %s is empty", env)
+		}
+		return v, nil
+	}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// Checker 是所有监听类型需要实现的接口
+// 每个实现对应 watchs 下的一个配置键，通过 Register 注册
+type Checker interface {
+	// Name 返回监听类型名称，即 watchs 下的配置键
+	Name() string
+	// Decode 解析 watchs.<name> 下的配置
+	Decode(node *yaml.Node) error
+	// Enabled 报告配置是否启用了该检测
+	Enabled() bool
+	// Check 执行检测
+	Check(ctx context.Context) (*Result, error)
+}
+
+// Env 提供创建检测器时需要的运行环境
+type Env struct {
+	// Root 为 config.yml 所在目录
+	Root string
+	// State 为上次成功运行后记录的值（state.yml 的 watchs.<name>），可能为 nil
+	State map[string]string
+}
+
+// Factory 创建一个未配置的检测器
+type Factory func(env Env) Checker
+
+var registry = map[string]Factory{}
+
+// Register 注册监听类型，通常在实现包的 init 中调用
+func Register(name string, f Factory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("check: %s registered twice", name))
+	}
+	registry[name] = f
+}
+
+// Names 返回已注册的监听类型（按名称排序）
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load 根据 watchs 配置创建所有启用的检测器，按名称排序返回
+// states 为 state.yml 中按监听类型记录的值
+func Load(watchs map[string]yaml.Node, root string, states map[string]map[string]string) ([]Checker, error) {
+	for n := range watchs {
+		if _, ok := registry[n]; !ok {
+			return nil, fmt.Errorf("unknown watch type: %s", n)
+		}
+	}
+	var out []Checker
+	for _, n := range Names() {
+		node, ok := watchs[n]
+		if !ok {
+			continue
+		}
+		c := registry[n](Env{Root: root, State: states[n]})
+		if err := c.Decode(&node); err != nil {
+			return nil, fmt.Errorf("watchs.%s: %w", n, err)
+		}
+		if c.Enabled() {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,450 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcr "github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+func init() {
+	check.Register("oci", func(env check.Env) check.Checker {
+		return &artifactChecker{root: env.Root, prev: env.State}
+	})
+}
+
+// Annotations read from artifact layers, as set by ORAS and similar tools.
+const (
+	// annotationTitle names the file a layer is written to.
+	annotationTitle = "org.opencontainers.image.title"
+	// annotationUnpack marks a titled layer as a tar archive of a directory.
+	annotationUnpack = "io.deis.oras.content.unpack"
+)
+
+// currentLink is the symlink in an artifact's directory that points to the
+// most recently extracted version.
+const currentLink = "current"
+
+// ArtifactConfig maps watchs.oci, which watches non-image OCI artifacts
+// (config bundles, static sites, ...) and extracts each new version into
+// .dg/artifacts/<name>/<digest>:
+//
+//	oci:
+//	  artifacts:
+//	    - registry.internal:5000/configs/app:prod
+//	    - {ref: registry.internal:5000/sites/docs:latest, name: docs}
+//	  keep: 3
+type ArtifactConfig struct {
+	Artifacts []Artifact `yaml:"artifacts"`
+	// Keep is how many extracted versions of each artifact are kept (default 3).
+	Keep int `yaml:"keep"`
+	// Registries is the same as watchs.docker.registries.
+	Registries map[string]Registry `yaml:"registries"`
+	// Timeout limits each digest lookup, retries included (default 1m).
+	Timeout time.Duration `yaml:"timeout"`
+	// OnError is fail (default), skip or warn; see check.ParseOnError.
+	OnError string `yaml:"on_error"`
+}
+
+// Artifact is one entry of watchs.oci.artifacts: a reference, or a mapping
+// with a name.
+type Artifact struct {
+	Ref string `yaml:"ref"`
+	// Name is the directory under .dg/artifacts and names the script
+	// variables; defaults to the last element of the repository.
+	Name string `yaml:"name"`
+}
+
+var artifactName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (a *Artifact) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if err := node.Decode(&a.Ref); err != nil {
+			return err
+		}
+	} else {
+		type plain Artifact
+		if err := node.Decode((*plain)(a)); err != nil {
+			return err
+		}
+	}
+	if a.Ref == "" {
+		return errors.New("artifact entry needs ref")
+	}
+	ref, err := name.ParseReference(a.Ref)
+	if err != nil {
+		return fmt.Errorf("artifact %s: %w", a.Ref, err)
+	}
+	if a.Name == "" {
+		a.Name = filepath.Base(ref.Context().RepositoryStr())
+	}
+	if !artifactName.MatchString(a.Name) {
+		return fmt.Errorf("artifact %s: name %q may only contain letters, digits, '.', '_' and '-'", a.Ref, a.Name)
+	}
+	return nil
+}
+
+type artifactChecker struct {
+	root string
+	prev map[string]string
+	cfg  ArtifactConfig
+}
+
+func (c *artifactChecker) Name() string { return "oci" }
+
+func (c *artifactChecker) Decode(node *yaml.Node) error {
+	if err := node.Decode(&c.cfg); err != nil {
+		return err
+	}
+	var err error
+	if c.cfg.OnError, err = check.ParseOnError(c.cfg.OnError); err != nil {
+		return err
+	}
+	if c.cfg.Keep <= 0 {
+		c.cfg.Keep = 3
+	}
+	if c.cfg.Timeout <= 0 {
+		c.cfg.Timeout = time.Minute
+	}
+	seen := make(map[string]string)
+	for _, a := range c.cfg.Artifacts {
+		if ref, ok := seen[a.Name]; ok {
+			return fmt.Errorf("artifacts %s and %s share the name %q; set a distinct name", ref, a.Ref, a.Name)
+		}
+		seen[a.Name] = a.Ref
+	}
+	for host, r := range c.cfg.Registries {
+		r.resolvePaths(c.root)
+		c.cfg.Registries[host] = r
+	}
+	_, err = newRegistries(c.cfg.Registries)
+	return err
+}
+
+func (c *artifactChecker) Enabled() bool { return len(c.cfg.Artifacts) > 0 }
+
+// Check compares each artifact's digest with prev (ref -> digest of the last
+// successful run). Changed artifacts get an action that extracts them before
+// the scripts run.
+func (c *artifactChecker) Check(ctx context.Context) (*check.Result, error) {
+	regs, err := newRegistries(c.cfg.Registries)
+	if err != nil {
+		return nil, err
+	}
+	res := &check.Result{State: make(map[string]string), Vars: make(map[string]string)}
+	for _, a := range c.cfg.Artifacts {
+		actx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		ref, digest, err := artifactDigest(actx, regs, a.Ref)
+		cancel()
+		last, deployed := c.prev[a.Ref]
+		if err != nil {
+			var keep map[string]string
+			if deployed {
+				keep = map[string]string{a.Ref: last}
+			}
+			if err := res.Tolerate("oci", "["+a.Ref+"] ", c.cfg.OnError, err, keep); err != nil {
+				return nil, fmt.Errorf("%s: %w", a.Ref, err)
+			}
+			continue
+		}
+		res.State[a.Ref] = digest.String()
+		base := filepath.Join(c.root, "artifacts", a.Name)
+		dir := filepath.Join(base, digest.Hex)
+		prefix := check.EnvName("oci", a.Name)
+		res.Vars[prefix+"_DIGEST"] = digest.String()
+		res.Vars[prefix+"_DIR"] = dir
+
+		switch _, statErr := os.Stat(dir); {
+		case !deployed:
+			res.Logs = append(res.Logs, fmt.Sprintf("%s not deployed yet; digest %s", a.Ref, digest))
+		case last != digest.String():
+			res.Logs = append(res.Logs, fmt.Sprintf("%s changed %s -> %s", a.Ref, last, digest))
+		case statErr != nil:
+			res.Logs = append(res.Logs, fmt.Sprintf("%s extracted directory %s is missing", a.Ref, dir))
+		default:
+			continue
+		}
+		res.Triggered = true
+		res.Actions = append(res.Actions, check.Action{
+			Desc: fmt.Sprintf("extract %s@%s to %s", a.Ref, digest, dir),
+			Run: func(ctx context.Context) error {
+				return extractArtifact(ctx, regs, ref, digest, base, c.cfg.Keep)
+			},
+		})
+	}
+	return res, nil
+}
+
+// artifactDigest returns the manifest digest ref points to. Indexes are
+// rejected: an artifact has to be a single manifest.
+func artifactDigest(ctx context.Context, regs registries, ref string) (name.Reference, v1.Hash, error) {
+	r, err := regs.parse(ref)
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	var desc *v1.Descriptor
+	err = regs.try(ctx, r.Context(), func(repo name.Repository, opts []ggcr.Option) error {
+		var err error
+		desc, err = ggcr.Head(retarget(r, repo), opts...)
+		return err
+	})
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	if desc.MediaType.IsIndex() {
+		return nil, v1.Hash{}, fmt.Errorf("reference is an image index (%s), not a single artifact manifest", desc.MediaType)
+	}
+	return r, desc.Digest, nil
+}
+
+// extractArtifact writes the layers of ref@digest into base/<digest hex>,
+// points base/current at it and prunes all but the keep newest versions.
+// A version already on disk is reused.
+func extractArtifact(ctx context.Context, regs registries, ref name.Reference, digest v1.Hash, base string, keep int) error {
+	dir := filepath.Join(base, digest.Hex)
+	if _, err := os.Stat(dir); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(dir, now, now); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(base, 0o755); err != nil {
+			return err
+		}
+		var tmp string
+		err := regs.try(ctx, ref.Context(), func(repo name.Repository, opts []ggcr.Option) error {
+			// every attempt starts from an empty directory: symlinks left by a
+			// failed one would make the same entries fail safeJoin
+			dir, err := os.MkdirTemp(base, ".tmp-")
+			if err != nil {
+				return err
+			}
+			if err := writeLayers(repo.Digest(digest.String()), opts, dir); err != nil {
+				os.RemoveAll(dir)
+				return err
+			}
+			tmp = dir
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		if err := os.Chmod(tmp, 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return err
+		}
+	}
+
+	link := filepath.Join(base, "."+currentLink+"-tmp")
+	_ = os.Remove(link)
+	if err := os.Symlink(digest.Hex, link); err != nil {
+		return err
+	}
+	if err := os.Rename(link, filepath.Join(base, currentLink)); err != nil {
+		return err
+	}
+	return pruneVersions(base, digest.Hex, keep)
+}
+
+// writeLayers fetches the artifact ref and writes all its layers into dir.
+func writeLayers(ref name.Digest, opts []ggcr.Option, dir string) error {
+	img, err := ggcr.Image(ref, opts...)
+	if err != nil {
+		return err
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range m.Layers {
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return err
+		}
+		if err := writeLayer(dir, desc, layer); err != nil {
+			return fmt.Errorf("layer %s: %w", desc.Digest, err)
+		}
+	}
+	return nil
+}
+
+// writeLayer stores a layer in dir. A layer titled by annotationTitle is
+// written to that file, or unpacked into that directory when
+// annotationUnpack is set; untitled layers are unpacked into dir itself.
+func writeLayer(dir string, desc v1.Descriptor, layer v1.Layer) error {
+	title := desc.Annotations[annotationTitle]
+	if title != "" && desc.Annotations[annotationUnpack] != "true" {
+		path, err := safeJoin(dir, title)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeFile(path, rc, 0o644)
+	}
+	target := dir
+	if title != "" {
+		var err error
+		if target, err = safeJoin(dir, title); err != nil {
+			return err
+		}
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	err = untar(rc, target)
+	if errors.Is(err, errNotTar) {
+		return fmt.Errorf("%s: %w; give it an %s annotation to store it as a file", desc.MediaType, err, annotationTitle)
+	}
+	return err
+}
+
+var errNotTar = errors.New("not a tar archive")
+
+// untar extracts regular files, directories and symlinks into dir. Entries
+// escaping dir or going through a symlink are rejected, and symlinks must be
+// relative without "..", so none can lead outside dir.
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && first {
+			return fmt.Errorf("%w: %v", errNotTar, err)
+		}
+		if err != nil {
+			return err
+		}
+		path, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(path, tr, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || check.Contains(strings.Split(filepath.ToSlash(hdr.Linkname), "/"), "..") {
+				return fmt.Errorf("symlink %s must point into its own directory or below: %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			// hard links, devices and the like have no place in a config bundle
+		}
+	}
+}
+
+// safeJoin joins name to dir, rejecting names that leave dir and paths
+// through a symlink below dir: an earlier entry of the same artifact could
+// have created it to redirect later writes outside dir.
+func safeJoin(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if path == dir {
+		return path, nil
+	}
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q leaves the artifact directory", name)
+	}
+	cur := dir
+	for _, part := range strings.Split(path[len(dir)+1:], string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		fi, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path %q goes through symlink %s", name, strings.TrimPrefix(cur, dir+string(filepath.Separator)))
+		}
+	}
+	return path, nil
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pruneVersions removes extracted versions in base beyond the keep most
+// recently used, never current.
+func pruneVersions(base, current string, keep int) error {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return err
+	}
+	type extracted struct {
+		name string
+		mod  time.Time
+	}
+	var versions []extracted
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || e.Name() == current {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		versions = append(versions, extracted{e.Name(), fi.ModTime()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].mod.After(versions[j].mod) })
+	for i, v := range versions {
+		// current counts towards keep
+		if i+1 < keep {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(base, v.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"gopkg.in/yaml.v3"
+)
+
+// tarEntry is a file (Body), directory (Dir) or symlink (Link) in a test archive.
+type tarEntry struct {
+	Name string
+	Body string
+	Dir  bool
+	Link string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.Body))}
+		switch {
+		case e.Dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0o755, 0
+		case e.Link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.Link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "v1")
+	err := untar(bytes.NewReader(buildTar(t, []tarEntry{
+		{Name: "conf/", Dir: true},
+		{Name: "conf/app.yml", Body: "a: 1\n"},
+		{Name: "app.yml", Link: "conf/app.yml"},
+	})), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "app.yml"))
+	if err != nil || string(b) != "a: 1\n" {
+		t.Fatalf("app.yml = %q, %v", b, err)
+	}
+}
+
+func TestUntarRejectsEscapes(t *testing.T) {
+	for name, entries := range map[string][]tarEntry{
+		// a/b -> .. and a/b/c -> .. would land a/b/c/evil next to the version directory
+		"chained symlinks": {
+			{Name: "a/", Dir: true},
+			{Name: "a/b", Link: ".."},
+			{Name: "a/b/c", Link: ".."},
+			{Name: "a/b/c/evil", Body: "x"},
+		},
+		"write through symlink": {
+			{Name: "a", Link: "."},
+			{Name: "a/evil", Body: "x"},
+		},
+		"dotdot in entry":   {{Name: "../evil", Body: "x"}},
+		"absolute symlink":  {{Name: "etc", Link: "/etc"}},
+		"dotdot in symlink": {{Name: "sub/up", Link: "../x"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "v1")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := untar(bytes.NewReader(buildTar(t, entries)), dir); err == nil {
+				t.Fatal("untar succeeded, want an error")
+			}
+			found, err := filepath.Glob(filepath.Join(parent, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 1 || found[0] != dir {
+				t.Fatalf("files outside the artifact directory: %v", found)
+			}
+		})
+	}
+}
+
+// pushArtifact pushes an ORAS-style artifact for version to ref: a titled
+// file, an untitled tar layer and a gzipped tar unpacked into site/.
+func pushArtifact(t *testing.T, ref, version string) string {
+	t.Helper()
+	var site bytes.Buffer
+	zw := gzip.NewWriter(&site)
+	if _, err := zw.Write(buildTar(t, []tarEntry{{Name: "index.html", Body: "<h1>" + version + "</h1>"}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), "application/vnd.example.config.v1+json")
+	img, err := mutate.Append(img,
+		mutate.Addendum{
+			Layer:       static.NewLayer([]byte("version="+version+"\n"), "text/plain"),
+			Annotations: map[string]string{annotationTitle: "app.env"},
+		},
+		mutate.Addendum{
+			Layer: static.NewLayer(buildTar(t, []tarEntry{{Name: "conf/app.yml", Body: "version: " + version + "\n"}}), types.OCIUncompressedLayer),
+		},
+		mutate.Addendum{
+			Layer:       static.NewLayer(site.Bytes(), types.OCILayer),
+			Annotations: map[string]string{annotationTitle: "site", annotationUnpack: "true"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(r, img); err != nil {
+		t.Fatal(err)
+	}
+	d, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.String()
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestArtifactChecker(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/configs/app:prod"
+
+	root := t.TempDir()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte("artifacts: ["+ref+"]\nkeep: 2\n"), &node); err != nil {
+		t.Fatal(err)
+	}
+	c := &artifactChecker{root: root}
+	if err := c.Decode(node.Content[0]); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(root, "artifacts", "app")
+	ctx := context.Background()
+
+	var digests []string
+	for _, version := range []string{"v1", "v2", "v3"} {
+		digest := pushArtifact(t, ref, version)
+		digests = append(digests, digest)
+
+		res, err := c.Check(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Triggered || len(res.Actions) != 1 {
+			t.Fatalf("%s: triggered=%v actions=%d, want a trigger and one action", version, res.Triggered, len(res.Actions))
+		}
+		if res.State[ref] != digest {
+			t.Fatalf("%s: state %q, want %q", version, res.State[ref], digest)
+		}
+		dir := res.Vars["DG_OCI_APP_DIR"]
+		if want := filepath.Join(base, strings.TrimPrefix(digest, "sha256:")); dir != want {
+			t.Fatalf("%s: DG_OCI_APP_DIR = %q, want %q", version, dir, want)
+		}
+		if err := res.Actions[0].Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, filepath.Join(dir, "app.env")); got != "version="+version+"\n" {
+			t.Errorf("%s: app.env = %q", version, got)
+		}
+		if got := readFile(t, filepath.Join(dir, "conf", "app.yml")); got != "version: "+version+"\n" {
+			t.Errorf("%s: conf/app.yml = %q", version, got)
+		}
+		if got := readFile(t, filepath.Join(dir, "site", "index.html")); got != "<h1>"+version+"</h1>" {
+			t.Errorf("%s: site/index.html = %q", version, got)
+		}
+		if got, err := os.Readlink(filepath.Join(base, currentLink)); err != nil || got != filepath.Base(dir) {
+			t.Errorf("%s: current -> %q, %v; want %q", version, got, err, filepath.Base(dir))
+		}
+
+		// the next run compares with what this one deployed
+		c.prev = res.State
+		res, err = c.Check(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Triggered || len(res.Actions) != 0 {
+			t.Fatalf("%s: unchanged artifact triggered", version)
+		}
+	}
+
+	// keep: 2 leaves v2 and v3 next to the current link
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{currentLink, strings.TrimPrefix(digests[1], "sha256:"), strings.TrimPrefix(digests[2], "sha256:")}
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("artifact directory holds %v, want %v", got, want)
+	}
+}
+
+func TestExtractArtifactRetry(t *testing.T) {
+	links := static.NewLayer(buildTar(t, []tarEntry{
+		{Name: "conf/app.yml", Body: "a: 1\n"},
+		{Name: "app.yml", Link: "conf/app.yml"},
+	}), types.OCIUncompressedLayer)
+	env := static.NewLayer([]byte("A=1\n"), "text/plain")
+	envDigest, err := env.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first download of the second layer fails, after the symlink of
+	// the first one has been written
+	var failed atomic.Bool
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/blobs/"+envDigest.String()) && failed.CompareAndSwap(false, true) {
+			http.Error(w, "blob unavailable", http.StatusNotFound)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1),
+		mutate.Addendum{Layer: links},
+		mutate.Addendum{Layer: env, Annotations: map[string]string{annotationTitle: "app.env"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "http://") + "/configs/app:prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs, err := newRegistries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := t.TempDir()
+	if err := extractArtifact(context.Background(), regs, ref, digest, base, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !failed.Load() {
+		t.Fatal("the layer download never failed")
+	}
+	dir := filepath.Join(base, digest.Hex)
+	if got := readFile(t, filepath.Join(dir, "app.yml")); got != "a: 1\n" {
+		t.Errorf("app.yml = %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "app.env")); got != "A=1\n" {
+		t.Errorf("app.env = %q", got)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(base, ".tmp-*")); len(tmp) != 0 {
+		t.Errorf("temporary directories left behind: %v", tmp)
+	}
+}
@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+// Compose configures watchs.docker.compose. It is either the path of a
+// compose file or a mapping:
+//
+//	compose:
+//	  file: ./docker-compose.yml
+//	  services: [api, worker]
+//	  env_file: ./.env
+type Compose struct {
+	File string `yaml:"file"`
+	// Services limits watching to these services; empty means all.
+	Services []string `yaml:"services"`
+	// EnvFile is used for ${VAR} interpolation; defaults to .env next to File.
+	EnvFile string `yaml:"env_file"`
+}
+
+func (c *Compose) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.File)
+	}
+	type plain Compose
+	return node.Decode((*plain)(c))
+}
+
+type composeService struct {
+	Name  string
+	Image string
+}
+
+// loadCompose returns the interpolated image of each selected service,
+// ordered by service name. Services that only build locally are skipped.
+func loadCompose(c Compose) ([]composeService, error) {
+	b, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", c.File, err)
+	}
+	envFile := c.EnvFile
+	if envFile == "" {
+		envFile = filepath.Join(filepath.Dir(c.File), ".env")
+	}
+	vars, err := readEnvFile(envFile)
+	if err != nil && !(c.EnvFile == "" && os.IsNotExist(err)) {
+		return nil, err
+	}
+	// as in compose, the shell environment overrides .env
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	for _, name := range c.Services {
+		if _, ok := doc.Services[name]; !ok {
+			return nil, fmt.Errorf("service %s not found in %s", name, c.File)
+		}
+	}
+	var out []composeService
+	for name, svc := range doc.Services {
+		if len(c.Services) > 0 && !check.Contains(c.Services, name) {
+			continue
+		}
+		if svc.Image == "" {
+			continue
+		}
+		img, err := interpolate(svc.Image, vars)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		out = append(out, composeService{Name: name, Image: img})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// readEnvFile parses KEY=value lines; quotes around values are removed.
+func readEnvFile(path string) (map[string]string, error) {
+	vars := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return vars, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		vars[strings.TrimSpace(k)] = v
+	}
+	return vars, sc.Err()
+}
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?message} and ${VAR?message}; $$ is a literal $.
+func interpolate(s string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			v, err := expand(s[i+2:i+end], vars)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += end
+		case next == '_' || isAlnum(next):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || isAlnum(s[j])) {
+				j++
+			}
+			b.WriteString(vars[s[i+1:j]])
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func expand(expr string, vars map[string]string) (string, error) {
+	j := 0
+	for j < len(expr) && (expr[j] == '_' || isAlnum(expr[j])) {
+		j++
+	}
+	name, rest := expr[:j], expr[j:]
+	if rest == "" {
+		return vars[name], nil
+	}
+	for _, op := range []string{":-", ":?", "-", "?"} {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		arg := rest[len(op):]
+		v, set := vars[name]
+		if set && (op[0] != ':' || v != "") {
+			return v, nil
+		}
+		if strings.HasSuffix(op, "?") {
+			return "", fmt.Errorf("required variable %s is missing: %s", name, arg)
+		}
+		return arg, nil
+	}
+	return "", fmt.Errorf("invalid interpolation ${%s}", expr)
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
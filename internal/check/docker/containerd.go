@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// containerdStore reads containerd's image store through the ctr CLI, which
+// ships with containerd and talks to its socket; the gRPC client would pull
+// in far more dependencies than a single lookup justifies.
+type containerdStore struct {
+	address   string
+	namespace string
+}
+
+func (s *containerdStore) lookup(ctx context.Context, ref string) (*localImage, error) {
+	full, err := containerdName(ref)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ctr", "--address", s.address, "--namespace", s.namespace, "images", "list", "name=="+full)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ctr images list %s: %v: %s", full, err, strings.TrimSpace(string(out)))
+	}
+	// REF TYPE DIGEST SIZE PLATFORMS LABELS; DIGEST is the target the tag
+	// was pulled as (index or manifest)
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 3 && f[0] == full {
+			return &localImage{Digests: []string{f[2]}}, nil
+		}
+	}
+	return nil, fmt.Errorf("no local image for %s: %w", ref, errNotFound)
+}
+
+// containerdName expands ref to the fully qualified name containerd stores,
+// e.g. postgres:17 -> docker.io/library/postgres:17.
+func containerdName(ref string) (string, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	reg := r.Context().RegistryStr()
+	if reg == name.DefaultRegistry {
+		reg = "docker.io"
+	}
+	full := reg + "/" + r.Context().RepositoryStr()
+	switch t := r.(type) {
+	case name.Tag:
+		return full + ":" + t.TagStr(), nil
+	case name.Digest:
+		return full + "@" + t.DigestStr(), nil
+	}
+	return "", fmt.Errorf("unsupported reference: %s", ref)
+}
@@ -1,104 +1,452 @@
 package docker
 
 import (
-    "encoding/json"
+    "context"
+    "errors"
     "fmt"
-    "os/exec"
+    "path/filepath"
+    "runtime"
+    "sort"
     "strings"
+    "sync"
     "time"
 
+    v1 "github.com/google/go-containerregistry/pkg/v1"
     ggcr "github.com/google/go-containerregistry/pkg/v1/remote"
     "github.com/google/go-containerregistry/pkg/name"
-    "github.com/google/go-containerregistry/pkg/authn"
+    "gopkg.in/yaml.v3"
+
+    "dg/internal/check"
 )
 
-type Result struct {
-    Updated bool
-    Details []string
+func init() {
+    check.Register("docker", func(env check.Env) check.Checker {
+        return &checker{root: env.Root, prev: env.State}
+    })
+}
+
+// Config maps watchs.docker.
+type Config struct {
+    Images []Image `yaml:"images"`
+    // Compare is local (image held by the local engine) or deployed (digest recorded in state.yml).
+    Compare string `yaml:"compare"`
+    // Engine is docker (default), podman or containerd; used in local mode.
+    Engine string `yaml:"engine"`
+    // Host is the engine endpoint, e.g. unix:///var/run/docker.sock or
+    // tcp://10.0.0.2:2376. Defaults to $DOCKER_HOST, $CONTAINER_HOST or
+    // $CONTAINERD_ADDRESS, then the engine's standard socket.
+    Host string    `yaml:"host"`
+    TLS  TLSConfig `yaml:"tls"`
+    // Namespace is the containerd namespace (default: default; Kubernetes uses k8s.io).
+    Namespace string `yaml:"namespace"`
+    // Platform (os/arch[/variant]) to resolve multi-arch images to; defaults to linux/<host arch>.
+    Platform string `yaml:"platform"`
+    // Compose adds the image of every service in a compose file.
+    Compose Compose `yaml:"compose"`
+    // Registries holds credentials, TLS settings and mirrors keyed by registry host.
+    Registries map[string]Registry `yaml:"registries"`
+    // Concurrency bounds how many images are checked at once (default 4).
+    Concurrency int `yaml:"concurrency"`
+    // Timeout limits each image check, retries included (default 1m).
+    Timeout time.Duration `yaml:"timeout"`
+    // OnError is fail (default: abort the run), skip (log and check the others)
+    // or warn (like skip, but the run ends as a warning); see check.ParseOnError.
+    OnError string `yaml:"on_error"`
 }
 
-func Check(images []string) (*Result, error) {
-    res := &Result{}
+type checker struct {
+    root string
+    prev map[string]string
+    cfg  Config
+}
+
+func (c *checker) Name() string { return "docker" }
+
+func (c *checker) Decode(node *yaml.Node) error {
+    if err := node.Decode(&c.cfg); err != nil {
+        return err
+    }
+    var err error
+    c.cfg.Compare, err = check.ParseCompare(c.cfg.Compare)
+    if err != nil {
+        return err
+    }
+    switch c.cfg.Engine {
+    case "":
+        c.cfg.Engine = EngineDocker
+    case EngineDocker, EnginePodman, EngineContainerd:
+    default:
+        return fmt.Errorf("engine must be %s, %s or %s, got %q", EngineDocker, EnginePodman, EngineContainerd, c.cfg.Engine)
+    }
+    c.cfg.OnError, err = check.ParseOnError(c.cfg.OnError)
+    if err != nil {
+        return err
+    }
+    if c.cfg.Concurrency <= 0 {
+        c.cfg.Concurrency = 4
+    }
+    if c.cfg.Timeout <= 0 {
+        c.cfg.Timeout = time.Minute
+    }
+    if _, err := parsePlatform(c.cfg.Platform); err != nil {
+        return fmt.Errorf("platform: %w", err)
+    }
+    for _, p := range []*string{&c.cfg.TLS.CAFile, &c.cfg.TLS.CertFile, &c.cfg.TLS.KeyFile, &c.cfg.Compose.File, &c.cfg.Compose.EnvFile} {
+        if *p != "" && !filepath.IsAbs(*p) {
+            *p = filepath.Clean(filepath.Join(c.root, *p))
+        }
+    }
+    for host, r := range c.cfg.Registries {
+        r.resolvePaths(c.root)
+        c.cfg.Registries[host] = r
+    }
+    _, err = newRegistries(c.cfg.Registries)
+    return err
+}
+
+func (c *checker) Enabled() bool { return len(c.cfg.Images) > 0 || c.cfg.Compose.File != "" }
+
+func (c *checker) Check(ctx context.Context) (*check.Result, error) {
+    return Check(ctx, c.cfg, c.prev)
+}
+
+// Check compares each image's remote digest with the local image, or with
+// prev (image -> digest of the last successful run) in deployed mode.
+func Check(ctx context.Context, cfg Config, prev map[string]string) (*check.Result, error) {
+    res := &check.Result{}
+    images := cfg.Images
+    // image -> compose services using it
+    services := make(map[string][]string)
+    if cfg.Compose.File != "" {
+        svcs, err := loadCompose(cfg.Compose)
+        if err != nil {
+            return nil, err
+        }
+        for _, s := range svcs {
+            if _, seen := services[s.Image]; !seen {
+                images = append(images, Image{Ref: s.Image})
+            }
+            services[s.Image] = append(services[s.Image], s.Name)
+        }
+    }
     if len(images) == 0 {
         return res, nil
     }
-    for _, img := range images {
-        remoteDigest, err := getRemoteDigest(img)
-        if err != nil {
+    deployed := cfg.Compare == check.CompareDeployed
+    platform, err := parsePlatform(cfg.Platform)
+    if err != nil {
+        return nil, err
+    }
+    regs, err := newRegistries(cfg.Registries)
+    if err != nil {
+        return nil, err
+    }
+    res.State = make(map[string]string)
+    res.Vars = make(map[string]string)
+    var store localStore
+    if !deployed {
+        if store, err = newLocalStore(cfg); err != nil {
             return nil, err
         }
-        localDigest, err := getLocalDigest(img)
-        if err != nil {
-            // local missing is treated as update
-            res.Updated = true
-            res.Details = append(res.Details, fmt.Sprintf("%s local missing; remote %s", img, remoteDigest))
+    }
+    // fixed references may be listed twice, e.g. in images and compose
+    var entries []Image
+    seen := make(map[string]bool)
+    for _, e := range images {
+        if e.Repo == "" {
+            if seen[e.Ref] {
+                continue
+            }
+            seen[e.Ref] = true
+        }
+        entries = append(entries, e)
+    }
+
+    // each entry gets its own result; they are merged in order afterwards so
+    // logs and state don't depend on which check finished first
+    type outcome struct {
+        res *check.Result
+        msg string
+        err error
+    }
+    outs := make([]outcome, len(entries))
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    var (
+        wg       sync.WaitGroup
+        failOnce sync.Once
+        firstErr error
+    )
+    sem := make(chan struct{}, cfg.Concurrency)
+    for i, entry := range entries {
+        wg.Add(1)
+        go func(i int, entry Image) {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            ictx, icancel := context.WithTimeout(ctx, cfg.Timeout)
+            defer icancel()
+            r := &check.Result{State: make(map[string]string), Vars: make(map[string]string)}
+            var msg string
+            var err error
+            if entry.Repo != "" {
+                // selected tags are always compared with state
+                err = checkSelector(ictx, regs, entry, platform, prev, r)
+            } else {
+                msg, err = checkImage(ictx, regs, entry.Ref, platform, store, prev, r.State)
+            }
+            if err != nil && cfg.OnError == check.OnErrorFail {
+                // stop the remaining checks; the run fails anyway
+                failOnce.Do(func() {
+                    firstErr = err
+                    cancel()
+                })
+            }
+            outs[i] = outcome{res: r, msg: msg, err: err}
+        }(i, entry)
+    }
+    wg.Wait()
+    if firstErr != nil {
+        return nil, firstErr
+    }
+
+    var affected []string
+    for i, o := range outs {
+        key := entries[i].String()
+        if o.err != nil {
+            // on_error: fail has returned above
+            var keep map[string]string
+            if last, ok := prev[key]; ok {
+                keep = map[string]string{key: last}
+            }
+            _ = res.Tolerate("docker", "["+key+"] ", cfg.OnError, o.err, keep)
             continue
         }
-        if localDigest != remoteDigest {
-            res.Updated = true
-            res.Details = append(res.Details, fmt.Sprintf("%s digest changed local %s -> remote %s", img, localDigest, remoteDigest))
+        res.Logs = append(res.Logs, o.res.Logs...)
+        for k, v := range o.res.State {
+            res.State[k] = v
+        }
+        for k, v := range o.res.Vars {
+            res.Vars[k] = v
         }
+        if o.res.Triggered {
+            res.Triggered = true
+        }
+        if o.msg == "" {
+            continue
+        }
+        res.Triggered = true
+        if svcs := services[key]; len(svcs) > 0 {
+            o.msg += fmt.Sprintf(" (services: %s)", strings.Join(svcs, ", "))
+            affected = append(affected, svcs...)
+        }
+        res.Logs = append(res.Logs, o.msg)
+    }
+    if len(affected) > 0 {
+        sort.Strings(affected)
+        res.Vars[check.EnvName("docker", "compose", "services")] = strings.Join(affected, " ")
+    }
+    if !deployed && len(res.State) == 0 {
+        res.State = nil
     }
     return res, nil
 }
 
-func getRemoteDigest(ref string) (string, error) {
-    r, err := name.ParseReference(ref)
+// checkImage compares one fixed reference and returns a description of the
+// change, or "" when it is up to date. A nil store means deployed mode.
+func checkImage(ctx context.Context, regs registries, img string, platform v1.Platform, store localStore, prev, state map[string]string) (string, error) {
+    remote, err := getRemote(ctx, regs, img, platform)
     if err != nil {
         return "", err
     }
-    // retry simple
-    var last error
-    for i := 0; i < 3; i++ {
-        desc, err := ggcr.Head(r, ggcr.WithAuthFromKeychain(authn.DefaultKeychain))
-        if err == nil {
-            d := desc.Digest.String()
-            if d != "" {
-                return d, nil
-            }
+    if store == nil {
+        // compare the platform manifest so pushes for other architectures don't trigger
+        last, ok := prev[img]
+        if err := remote.resolveSince(ctx, ok, last); err != nil {
+            return "", err
+        }
+        state[img] = remote.recorded()
+        if !ok {
+            return fmt.Sprintf("%s not deployed yet; remote %s", img, remote.Manifest), nil
         }
-        last = err
-        time.Sleep(time.Duration(1<<i) * 200 * time.Millisecond)
+        if _, manifest := splitRecorded(last); manifest != remote.Manifest {
+            return fmt.Sprintf("%s digest changed deployed %s -> remote %s", img, manifest, remote.Manifest), nil
+        }
+        return "", nil
+    }
+    local, err := store.lookup(ctx, img)
+    if errors.Is(err, errNotFound) {
+        // local missing is treated as update
+        return fmt.Sprintf("%s local missing; remote %s", img, remote.Digest), nil
+    }
+    if err != nil {
+        return "", err
+    }
+    if check.Contains(local.Digests, remote.Digest) {
+        return "", nil
+    }
+    // repo digests are compared with the manifest; an image ID needs the config digest
+    resolve := remote.resolve
+    if len(local.Digests) > 0 {
+        resolve = remote.resolveManifest
+    }
+    if err := resolve(ctx); err != nil {
+        return "", err
+    }
+    if remote.matches(local) {
+        return "", nil
     }
-    if last != nil {
-        return "", last
+    if len(local.Digests) > 0 {
+        return fmt.Sprintf("%s digest changed local %s -> remote %s", img, local.Digests[0], remote.Manifest), nil
     }
-    return "", fmt.Errorf("remote digest not found for %s", ref)
+    return fmt.Sprintf("%s image id changed local %s -> remote %s", img, local.ID, remote.Config), nil
 }
 
-type dockerInspect struct {
-    RepoDigests []string `json:"RepoDigests"`
-    ID          string   `json:"Id"`
+// remoteImage describes a tag in the registry, resolved to one platform.
+type remoteImage struct {
+    regs     registries
+    ref      name.Reference
+    platform v1.Platform
+    // index is true when the tag points to a multi-arch index
+    index bool
+    // Digest is what the tag points to: an index for multi-arch images.
+    Digest string
+    // Manifest is the platform-specific manifest digest (same as Digest for single-arch images).
+    Manifest string
+    // Config is the config blob digest, which engines report as the image ID.
+    Config string
 }
 
-func getLocalDigest(ref string) (string, error) {
-    // use docker CLI to avoid heavy client deps
-    cmd := exec.Command("bash", "-lc", fmt.Sprintf("docker image inspect %q", ref))
-    out, err := cmd.Output()
+// matches compares like with like: repo digests against the index or
+// platform manifest, and the image ID against the config digest.
+func (r *remoteImage) matches(l *localImage) bool {
+    if len(l.Digests) > 0 {
+        return check.Contains(l.Digests, r.Digest) || check.Contains(l.Digests, r.Manifest)
+    }
+    // docker's containerd image store reports the index digest as the ID
+    return l.ID != "" && (l.ID == r.Config || l.ID == r.Digest)
+}
+
+// getRemote only issues a HEAD; resolve does the GETs, which count against
+// Docker Hub's pull rate limit, when the digest alone can't decide.
+func getRemote(ctx context.Context, regs registries, ref string, platform v1.Platform) (*remoteImage, error) {
+    r, err := regs.parse(ref)
     if err != nil {
-        return "", err
+        return nil, err
     }
-    var objs []dockerInspect
-    if err := json.Unmarshal(out, &objs); err != nil {
-        return "", err
+    ri := &remoteImage{regs: regs, ref: r, platform: platform}
+    err = regs.try(ctx, r.Context(), func(repo name.Repository, opts []ggcr.Option) error {
+        desc, err := ggcr.Head(retarget(r, repo), opts...)
+        if err != nil {
+            return err
+        }
+        if desc.Digest.String() == "" {
+            return fmt.Errorf("remote digest not found for %s", ref)
+        }
+        ri.Digest = desc.Digest.String()
+        ri.index = desc.MediaType.IsIndex()
+        return nil
+    })
+    if err != nil {
+        return nil, err
     }
-    if len(objs) == 0 {
-        return "", fmt.Errorf("no local image for %s", ref)
+    return ri, nil
+}
+
+// resolveManifest fills Manifest; only an index needs resolve's GETs, a
+// single-arch manifest is already known from the HEAD.
+func (r *remoteImage) resolveManifest(ctx context.Context) error {
+    if !r.index {
+        r.Manifest = r.Digest
+        return nil
     }
-    img := objs[0]
-    if len(img.RepoDigests) > 0 {
-        for _, d := range img.RepoDigests {
-            if strings.HasPrefix(d, ref+"@") {
-                return strings.TrimPrefix(d, ref+"@"), nil
-            }
+    return r.resolve(ctx)
+}
+
+// resolveSince fills Manifest like resolveManifest, but takes it from last,
+// a value recorded by recorded(), when the tag still points to the same
+// digest; with a cron every few minutes the GETs would otherwise use up
+// Docker Hub's pull rate limit.
+func (r *remoteImage) resolveSince(ctx context.Context, ok bool, last string) error {
+    if index, manifest := splitRecorded(last); ok && (r.Digest == index || r.Digest == manifest) {
+        r.Manifest = manifest
+        return nil
+    }
+    return r.resolveManifest(ctx)
+}
+
+// recorded is the value kept in state.yml for the image: the platform
+// manifest, prefixed with the index digest ("<index>@<manifest>") for
+// multi-arch images so an unchanged index is recognized from the HEAD alone.
+func (r *remoteImage) recorded() string {
+    if r.index && r.Digest != r.Manifest {
+        return r.Digest + "@" + r.Manifest
+    }
+    return r.Manifest
+}
+
+// splitRecorded splits a value written by recorded; index is empty for
+// single-arch images.
+func splitRecorded(v string) (index, manifest string) {
+    if i := strings.Index(v, "@"); i >= 0 {
+        return v[:i], v[i+1:]
+    }
+    return "", v
+}
+
+// resolve fills Manifest and Config for the configured platform.
+func (r *remoteImage) resolve(ctx context.Context) error {
+    if r.Config != "" {
+        return nil
+    }
+    return r.regs.try(ctx, r.ref.Context(), func(repo name.Repository, opts []ggcr.Option) error {
+        ref := retarget(r.ref, repo)
+        desc, err := ggcr.Get(ref, append(opts, ggcr.WithPlatform(r.platform))...)
+        if err != nil {
+            return err
         }
-        parts := strings.Split(img.RepoDigests[0], "@")
-        if len(parts) == 2 {
-            return parts[1], nil
+        // Image picks the manifest matching the platform out of an index
+        img, err := desc.Image()
+        if err != nil {
+            return fmt.Errorf("%s: %w", ref, err)
         }
+        m, err := img.Digest()
+        if err != nil {
+            return err
+        }
+        cfg, err := img.ConfigName()
+        if err != nil {
+            return err
+        }
+        r.Digest, r.Manifest, r.Config = desc.Digest.String(), m.String(), cfg.String()
+        return nil
+    })
+}
+
+// retry simple; gives up early when ctx is done
+func retry(ctx context.Context, fn func() error) error {
+    var last error
+    for i := 0; i < 3; i++ {
+        if last = fn(); last == nil {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return last
+        case <-time.After(time.Duration(1<<i) * 200 * time.Millisecond):
+        }
+    }
+    return last
+}
+
+// parsePlatform parses os/arch[/variant], defaulting to the host's architecture.
+func parsePlatform(s string) (v1.Platform, error) {
+    if s == "" {
+        return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}, nil
     }
-    if img.ID != "" {
-        return img.ID, nil
+    p, err := v1.ParsePlatform(s)
+    if err != nil {
+        return v1.Platform{}, err
     }
-    return "", fmt.Errorf("no local digest for %s", ref)
+    return *p, nil
 }
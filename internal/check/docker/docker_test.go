@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushIndex pushes a linux/amd64 + linux/arm64 index to ref and returns the
+// amd64 manifest digest.
+func pushIndex(t *testing.T, ref string, amd64, arm64 v1.Image) string {
+	t.Helper()
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(r, idx); err != nil {
+		t.Fatal(err)
+	}
+	d, err := amd64.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.String()
+}
+
+func randomImage(t *testing.T) v1.Image {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestCheckImageDeployed(t *testing.T) {
+	var gets atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/manifests/") {
+			gets.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/library/app:latest"
+	regs, err := newRegistries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := v1.Platform{OS: "linux", Architecture: "amd64"}
+	ctx := context.Background()
+	run := func(prev map[string]string) (string, map[string]string) {
+		t.Helper()
+		state := make(map[string]string)
+		msg, err := checkImage(ctx, regs, ref, platform, nil, prev, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg, state
+	}
+
+	amd64 := randomImage(t)
+	manifest := pushIndex(t, ref, amd64, randomImage(t))
+	gets.Store(0)
+	msg, state := run(nil)
+	if msg == "" || !strings.HasSuffix(state[ref], "@"+manifest) {
+		t.Fatalf("first run: msg=%q state=%q, want a trigger recording %s", msg, state[ref], manifest)
+	}
+
+	// an unchanged index is recognized from the HEAD alone
+	gets.Store(0)
+	msg, next := run(state)
+	if msg != "" || next[ref] != state[ref] {
+		t.Fatalf("unchanged: msg=%q state=%q", msg, next[ref])
+	}
+	if n := gets.Load(); n != 0 {
+		t.Fatalf("unchanged index issued %d manifest GETs, want 0", n)
+	}
+
+	// a new arm64 image changes the index but not the deployed platform
+	pushIndex(t, ref, amd64, randomImage(t))
+	msg, next = run(state)
+	if msg != "" || next[ref] == state[ref] || !strings.HasSuffix(next[ref], "@"+manifest) {
+		t.Fatalf("other platform: msg=%q state=%q", msg, next[ref])
+	}
+
+	// a new amd64 image triggers
+	state = next
+	manifest = pushIndex(t, ref, randomImage(t), randomImage(t))
+	msg, next = run(state)
+	if msg == "" || !strings.HasSuffix(next[ref], "@"+manifest) {
+		t.Fatalf("new image: msg=%q state=%q", msg, next[ref])
+	}
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errNotFound is returned for 404 responses from the engine.
+var errNotFound = errors.New("not found")
+
+// TLSConfig holds client certificates for a tcp:// engine endpoint.
+type TLSConfig struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != ""
+}
+
+// tlsFromEnv mirrors the docker CLI: DOCKER_CERT_PATH holds ca.pem, cert.pem
+// and key.pem and is used when DOCKER_TLS_VERIFY is set.
+func tlsFromEnv() TLSConfig {
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if os.Getenv("DOCKER_TLS_VERIFY") == "" || dir == "" {
+		return TLSConfig{}
+	}
+	return TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+}
+
+// engine is a minimal Docker Engine API client; only image inspect is needed.
+// Podman serves the same API shape under a /libpod prefix.
+type engine struct {
+	client *http.Client
+	base   *url.URL
+	prefix string
+}
+
+// newEngine builds a client for host (unix:// or tcp://); prefix is prepended
+// to every request path.
+func newEngine(host string, tlsCfg TLSConfig, prefix string) (*engine, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid engine host %q: %w", host, err)
+	}
+	tr := &http.Transport{}
+	base := &url.URL{Scheme: "http"}
+	switch u.Scheme {
+	case "unix":
+		sock := u.Path
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}
+		// the host is ignored when dialing a socket but must be a valid name
+		base.Host = "docker"
+	case "tcp", "http", "https":
+		base.Host = u.Host
+		if u.Scheme == "https" || tlsCfg.enabled() {
+			c, err := loadTLS(tlsCfg)
+			if err != nil {
+				return nil, err
+			}
+			tr.TLSClientConfig = c
+			base.Scheme = "https"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported engine host scheme: %s", host)
+	}
+	return &engine{client: &http.Client{Transport: tr}, base: base, prefix: prefix}, nil
+}
+
+func loadTLS(cfg TLSConfig) (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// get issues a GET against the engine and decodes a JSON response into v.
+func (e *engine) get(ctx context.Context, path string, v interface{}) error {
+	u := *e.base
+	u.Path = e.prefix + path
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &msg) == nil && msg.Message != "" {
+			return fmt.Errorf("engine %s: %s", resp.Status, msg.Message)
+		}
+		return fmt.Errorf("engine %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+type dockerInspect struct {
+	RepoDigests []string `json:"RepoDigests"`
+	ID          string   `json:"Id"`
+	// Digest is only reported by podman.
+	Digest string `json:"Digest"`
+}
+
+// inspect returns GET /images/{name}/json for ref.
+func (e *engine) inspect(ctx context.Context, ref string) (*dockerInspect, error) {
+	var img dockerInspect
+	if err := e.get(ctx, "/images/"+ref+"/json", &img); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, fmt.Errorf("no local image for %s: %w", ref, err)
+		}
+		return nil, fmt.Errorf("inspect %s: %w", ref, err)
+	}
+	return &img, nil
+}
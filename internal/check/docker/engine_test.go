@@ -0,0 +1,114 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveEngine serves handler on a unix socket and returns its unix:// host.
+func serveEngine(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	// t.TempDir can exceed the 108-byte socket path limit
+	dir, err := os.MkdirTemp("", "dg-engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "engine.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return "unix://" + sock
+}
+
+// fakeEngine answers image inspect for nginx under prefix and 404s everything else.
+func fakeEngine(t *testing.T, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+		switch r.URL.Path {
+		case prefix + "/images/nginx:1.27/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{
+				"Id": "sha256:1111",
+				"RepoDigests": ["mirror.example.com/library/nginx@sha256:3333", "nginx@sha256:2222"],
+				"Digest": "sha256:4444"
+			}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "No such image: ` + strings.TrimPrefix(r.URL.Path, prefix) + `"}`))
+		}
+	})
+}
+
+func TestEngineInspect(t *testing.T) {
+	e, err := newEngine(serveEngine(t, fakeEngine(t, "")), TLSConfig{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	img, err := e.inspect(ctx, "nginx:1.27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != "sha256:1111" || len(img.RepoDigests) != 2 || img.Digest != "sha256:4444" {
+		t.Fatalf("inspect = %+v", img)
+	}
+
+	if _, err := e.inspect(ctx, "redis:7"); !errors.Is(err, errNotFound) {
+		t.Fatalf("missing image: err = %v, want errNotFound", err)
+	}
+}
+
+func TestEngineErrorMessage(t *testing.T) {
+	host := serveEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "daemon is shutting down"}`))
+	}))
+	e, err := newEngine(host, TLSConfig{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.inspect(context.Background(), "nginx:1.27")
+	if err == nil || errors.Is(err, errNotFound) || !strings.Contains(err.Error(), "daemon is shutting down") {
+		t.Fatalf("err = %v, want the engine message", err)
+	}
+}
+
+func TestPodmanStore(t *testing.T) {
+	prefix := "/v4.0.0/libpod"
+	s, err := newLocalStore(Config{Engine: EnginePodman, Host: serveEngine(t, fakeEngine(t, prefix))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	img, err := s.lookup(ctx, "nginx:1.27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the digest recorded for nginx wins over the mirror's; podman's own digest is appended
+	if img.ID != "sha256:1111" || strings.Join(img.Digests, " ") != "sha256:2222 sha256:4444" {
+		t.Fatalf("lookup = %+v", img)
+	}
+
+	if _, err := s.lookup(ctx, "redis:7"); !errors.Is(err, errNotFound) {
+		t.Fatalf("missing image: err = %v, want errNotFound", err)
+	}
+}
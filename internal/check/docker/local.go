@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Engines supported for local image lookup (watchs.docker.engine).
+const (
+	EngineDocker     = "docker"
+	EnginePodman     = "podman"
+	EngineContainerd = "containerd"
+)
+
+// localImage is what a local engine knows about an image.
+type localImage struct {
+	// Digests are the repo digests recorded at pull time (index or manifest).
+	Digests []string
+	// ID is the image ID, usually the config digest.
+	ID string
+}
+
+// localStore looks up an image held by the local engine.
+// A missing image is reported with an error wrapping errNotFound.
+type localStore interface {
+	lookup(ctx context.Context, ref string) (*localImage, error)
+}
+
+func newLocalStore(cfg Config) (localStore, error) {
+	switch cfg.Engine {
+	case EngineDocker:
+		host := firstNonEmpty(cfg.Host, os.Getenv("DOCKER_HOST"), "unix:///var/run/docker.sock")
+		tlsCfg := cfg.TLS
+		if !tlsCfg.enabled() {
+			tlsCfg = tlsFromEnv()
+		}
+		e, err := newEngine(host, tlsCfg, "")
+		if err != nil {
+			return nil, err
+		}
+		return &apiStore{e: e}, nil
+	case EnginePodman:
+		host := firstNonEmpty(cfg.Host, os.Getenv("CONTAINER_HOST"), podmanSocket())
+		e, err := newEngine(host, cfg.TLS, "/v4.0.0/libpod")
+		if err != nil {
+			return nil, err
+		}
+		return &apiStore{e: e}, nil
+	case EngineContainerd:
+		addr := firstNonEmpty(cfg.Host, os.Getenv("CONTAINERD_ADDRESS"), "/run/containerd/containerd.sock")
+		ns := firstNonEmpty(cfg.Namespace, os.Getenv("CONTAINERD_NAMESPACE"), "default")
+		return &containerdStore{address: strings.TrimPrefix(addr, "unix://"), namespace: ns}, nil
+	}
+	return nil, fmt.Errorf("unsupported engine: %s", cfg.Engine)
+}
+
+// podmanSocket returns the rootless socket for non-root users and the
+// system socket otherwise.
+func podmanSocket() string {
+	if os.Getuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+}
+
+// apiStore looks images up through the Docker or Podman REST API.
+type apiStore struct {
+	e *engine
+}
+
+func (s *apiStore) lookup(ctx context.Context, ref string) (*localImage, error) {
+	img, err := s.e.inspect(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	li := &localImage{ID: img.ID}
+	repo := repoName(ref)
+	var other []string
+	for _, d := range img.RepoDigests {
+		parts := strings.SplitN(d, "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if repoName(parts[0]) == repo {
+			li.Digests = append(li.Digests, parts[1])
+		} else {
+			other = append(other, parts[1])
+		}
+	}
+	if len(li.Digests) == 0 {
+		// pulled under another name (e.g. a mirror); its digests still identify the content
+		li.Digests = other
+	}
+	// podman records the manifest digest it pulled
+	if img.Digest != "" {
+		li.Digests = append(li.Digests, img.Digest)
+	}
+	return li, nil
+}
+
+// repoName normalizes a reference to its repository, e.g. postgres:17 and
+// docker.io/library/postgres both become index.docker.io/library/postgres.
+func repoName(ref string) string {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return r.Context().Name()
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"dg/internal/check"
+)
+
+// Registry configures access to one registry host under watchs.docker.registries:
+//
+//	registries:
+//	  registry.internal:5000:
+//	    username: deploy
+//	    password_env: REGISTRY_PASSWORD
+//	    ca_file: ./certs/registry-ca.pem
+//	  docker.io:
+//	    mirrors: [mirror.internal:5000]
+//
+// Without credentials the default Docker keychain (~/.docker/config.json) is used.
+type Registry struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordEnv  string `yaml:"password_env"`
+	PasswordFile string `yaml:"password_file"`
+	// Token is sent as a bearer token instead of username/password.
+	Token     string `yaml:"token"`
+	TokenEnv  string `yaml:"token_env"`
+	TokenFile string `yaml:"token_file"`
+	// Insecure allows plain HTTP and skips TLS verification.
+	Insecure bool   `yaml:"insecure"`
+	CAFile   string `yaml:"ca_file"`
+	// Mirrors are tried in order before the registry itself, e.g. pull-through caches.
+	Mirrors []string `yaml:"mirrors"`
+}
+
+// registries applies the per-host settings to go-containerregistry calls.
+// Keys are normalized with name.NewRegistry so docker.io and index.docker.io match.
+type registries map[string]Registry
+
+func newRegistries(cfg map[string]Registry) (registries, error) {
+	rs := make(registries, len(cfg))
+	for host, r := range cfg {
+		reg, err := name.NewRegistry(host)
+		if err != nil {
+			return nil, fmt.Errorf("registries: %w", err)
+		}
+		rs[reg.RegistryStr()] = r
+	}
+	return rs, nil
+}
+
+// parse parses ref, allowing plain HTTP for insecure registries.
+func (rs registries) parse(ref string) (name.Reference, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if rs[r.Context().RegistryStr()].Insecure {
+		return name.ParseReference(ref, name.Insecure)
+	}
+	return r, nil
+}
+
+// repository parses repo, allowing plain HTTP for insecure registries.
+func (rs registries) repository(repo string) (name.Repository, error) {
+	r, err := name.NewRepository(repo)
+	if err != nil {
+		return r, err
+	}
+	if rs[r.RegistryStr()].Insecure {
+		return name.NewRepository(repo, name.Insecure)
+	}
+	return r, nil
+}
+
+// try calls fn for each mirror of repo's registry and then repo itself, with
+// retries, until one succeeds. fn receives the repository to use and the
+// matching transport and auth options.
+func (rs registries) try(ctx context.Context, repo name.Repository, fn func(repo name.Repository, opts []ggcr.Option) error) error {
+	var errs []error
+	for _, m := range rs[repo.RegistryStr()].Mirrors {
+		mirror, err := rs.repository(m + "/" + repo.RepositoryStr())
+		if err != nil {
+			return fmt.Errorf("mirror %s: %w", m, err)
+		}
+		opts, err := rs.options(ctx, mirror.Registry)
+		if err != nil {
+			return err
+		}
+		err = retry(ctx, func() error { return fn(mirror, opts) })
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("mirror %s: %w", m, err))
+	}
+	opts, err := rs.options(ctx, repo.Registry)
+	if err != nil {
+		return err
+	}
+	err = retry(ctx, func() error { return fn(repo, opts) })
+	if err == nil {
+		return nil
+	}
+	return errors.Join(append(errs, err)...)
+}
+
+func (rs registries) options(ctx context.Context, reg name.Registry) ([]ggcr.Option, error) {
+	opts := []ggcr.Option{ggcr.WithContext(ctx)}
+	r, ok := rs[reg.RegistryStr()]
+	if !ok {
+		return append(opts, ggcr.WithAuthFromKeychain(authn.DefaultKeychain)), nil
+	}
+	auth, err := r.authenticator()
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %w", reg.RegistryStr(), err)
+	}
+	if auth != nil {
+		opts = append(opts, ggcr.WithAuth(auth))
+	} else {
+		opts = append(opts, ggcr.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+	if r.Insecure || r.CAFile != "" {
+		tr := ggcr.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: r.Insecure}
+		if r.CAFile != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			pem, err := os.ReadFile(r.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("registry %s: %w", reg.RegistryStr(), err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("registry %s: no certificates found in %s", reg.RegistryStr(), r.CAFile)
+			}
+			tr.TLSClientConfig.RootCAs = pool
+		}
+		opts = append(opts, ggcr.WithTransport(tr))
+	}
+	return opts, nil
+}
+
+// authenticator returns nil when no credentials are configured.
+func (r Registry) authenticator() (authn.Authenticator, error) {
+	token, err := check.Secret(r.Token, r.TokenEnv, r.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		return authn.FromConfig(authn.AuthConfig{RegistryToken: token}), nil
+	}
+	password, err := check.Secret(r.Password, r.PasswordEnv, r.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.Username == "" && password == "" {
+		return nil, nil
+	}
+	return &authn.Basic{Username: r.Username, Password: password}, nil
+}
+
+// resolvePaths makes file paths relative to root absolute.
+func (r *Registry) resolvePaths(root string) {
+	for _, p := range []*string{&r.PasswordFile, &r.TokenFile, &r.CAFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Clean(filepath.Join(root, *p))
+		}
+	}
+}
+
+// retarget points ref at repo, keeping its tag or digest.
+func retarget(ref name.Reference, repo name.Repository) name.Reference {
+	if d, ok := ref.(name.Digest); ok {
+		return repo.Digest(d.DigestStr())
+	}
+	return repo.Tag(ref.Identifier())
+}
@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a parsed semantic version; a leading "v" and missing minor or
+// patch numbers are accepted, as registries are full of tags like v1.4 or 17.
+type version struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseVersion(s string) (version, bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	var v version
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+		if v.pre == "" {
+			return version{}, false
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return version{}, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return version{}, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+func (v version) compare(o version) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			if d < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1 // a release sorts after its prereleases
+	case o.pre == "":
+		return -1
+	}
+	return comparePre(v.pre, o.pre)
+}
+
+// comparePre orders dot-separated prerelease identifiers as semver does.
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// comparator is a single "<op><version>" term of a range.
+type comparator struct {
+	op string
+	v  version
+}
+
+func (c comparator) match(v version) bool {
+	n := v.compare(c.v)
+	switch c.op {
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	}
+	return n == 0
+}
+
+// constraint is a semver range: space-separated comparators are ANDed and
+// "||" separates alternatives, e.g. ">=1.4.0 <2.0.0 || ^3.1". Supported
+// operators are =, >, >=, <, <=, ^ and ~.
+type constraint [][]comparator
+
+func parseConstraint(s string) (constraint, error) {
+	var c constraint
+	for _, alt := range strings.Split(s, "||") {
+		var set []comparator
+		for _, term := range strings.Fields(alt) {
+			cs, err := parseTerm(term)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, cs...)
+		}
+		if len(set) == 0 {
+			return nil, fmt.Errorf("empty version range in %q", s)
+		}
+		c = append(c, set)
+	}
+	return c, nil
+}
+
+func parseTerm(term string) ([]comparator, error) {
+	op := ""
+	for _, p := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, p) {
+			op = p
+			break
+		}
+	}
+	v, ok := parseVersion(strings.TrimPrefix(term, op))
+	if !ok {
+		return nil, fmt.Errorf("invalid version %q", term)
+	}
+	switch op {
+	case "^":
+		// ^1.2.3 := >=1.2.3 <2.0.0, ^0.2.3 := >=0.2.3 <0.3.0
+		upper := version{major: v.major + 1}
+		if v.major == 0 {
+			upper = version{minor: v.minor + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "~":
+		// ~1.2.3 := >=1.2.3 <1.3.0
+		return []comparator{{">=", v}, {"<", version{major: v.major, minor: v.minor + 1}}}, nil
+	case "":
+		op = "="
+	}
+	return []comparator{{op, v}}, nil
+}
+
+// match reports whether v satisfies the range. Prereleases never match, so
+// 2.0.0-rc.1 is not picked up by ">=1.4.0".
+func (c constraint) match(v version) bool {
+	if v.pre != "" {
+		return false
+	}
+	for _, set := range c {
+		ok := true
+		for _, cmp := range set {
+			if !cmp.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
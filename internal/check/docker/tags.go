@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcr "github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+// Image is one entry of watchs.docker.images: either a fixed reference such
+// as "postgres:17", or a repository whose tags are listed and filtered:
+//
+//   - {repo: myorg/api, tags: ">=1.4.0 <2.0.0"}
+//   - {repo: myorg/web, regex: '^v\d+\.\d+\.\d+$'}
+type Image struct {
+	Ref string `yaml:"-"`
+	// Repo is the repository whose tags are listed.
+	Repo string `yaml:"repo"`
+	// Tags is a semver range the selected tag must satisfy.
+	Tags string `yaml:"tags"`
+	// Regex is a regular expression the selected tag must match.
+	Regex string `yaml:"regex"`
+	// Name overrides the repository in script variable names.
+	Name string `yaml:"name"`
+
+	constraint constraint
+	regex      *regexp.Regexp
+}
+
+func (i *Image) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&i.Ref)
+	}
+	type plain Image
+	if err := node.Decode((*plain)(i)); err != nil {
+		return err
+	}
+	if i.Repo == "" {
+		return errors.New("image entry needs repo")
+	}
+	if i.Tags == "" && i.Regex == "" {
+		return fmt.Errorf("image %s needs tags or regex", i.Repo)
+	}
+	if i.Tags != "" {
+		c, err := parseConstraint(i.Tags)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", i.Repo, err)
+		}
+		i.constraint = c
+	}
+	if i.Regex != "" {
+		re, err := regexp.Compile(i.Regex)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", i.Repo, err)
+		}
+		i.regex = re
+	}
+	return nil
+}
+
+// String returns the reference, or the repository and its selector; it is
+// also the entry's key in state.yml.
+func (i Image) String() string {
+	if i.Repo == "" {
+		return i.Ref
+	}
+	var sel []string
+	if i.Tags != "" {
+		sel = append(sel, i.Tags)
+	}
+	if i.Regex != "" {
+		sel = append(sel, "/"+i.Regex+"/")
+	}
+	return fmt.Sprintf("%s [%s]", i.Repo, strings.Join(sel, " "))
+}
+
+// selectTag returns the highest tag passing the filters. Semver tags order
+// by version and rank above anything else, which orders lexically.
+func (i Image) selectTag(tags []string) (string, bool) {
+	best, bestV, bestOK := "", version{}, false
+	for _, t := range tags {
+		if i.regex != nil && !i.regex.MatchString(t) {
+			continue
+		}
+		v, ok := parseVersion(t)
+		if i.constraint != nil && (!ok || !i.constraint.match(v)) {
+			continue
+		}
+		newer := false
+		switch {
+		case best == "":
+			newer = true
+		case ok && bestOK:
+			newer = v.compare(bestV) > 0
+		case ok != bestOK:
+			newer = ok
+		default:
+			newer = t > best
+		}
+		if newer {
+			best, bestV, bestOK = t, v, ok
+		}
+	}
+	return best, best != ""
+}
+
+// checkSelector lists the repository's tags, picks the highest match and
+// compares "<repo>:<tag>@<digest>" with the value recorded in prev.
+func checkSelector(ctx context.Context, regs registries, img Image, platform v1.Platform, prev map[string]string, res *check.Result) error {
+	key := img.String()
+	repo, err := regs.repository(img.Repo)
+	if err != nil {
+		return err
+	}
+	var tags []string
+	err = regs.try(ctx, repo, func(repo name.Repository, opts []ggcr.Option) error {
+		var err error
+		tags, err = ggcr.List(repo, opts...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("list tags of %s: %w", img.Repo, err)
+	}
+	tag, ok := img.selectTag(tags)
+	if !ok {
+		res.Logs = append(res.Logs, fmt.Sprintf("%s no matching tag among %d", key, len(tags)))
+		if last, ok := prev[key]; ok {
+			res.State[key] = last
+		}
+		return nil
+	}
+	remote, err := getRemote(ctx, regs, img.Repo+":"+tag, platform)
+	if err != nil {
+		return err
+	}
+	// state holds "<repo>:<tag>@" followed by remoteImage.recorded()
+	tagRef := img.Repo + ":" + tag
+	last, ok := prev[key]
+	lastRef, lastDigests := last, ""
+	if i := strings.Index(last, "@"); i >= 0 {
+		lastRef, lastDigests = last[:i], last[i+1:]
+	}
+	if err := remote.resolveSince(ctx, ok && lastRef == tagRef, lastDigests); err != nil {
+		return err
+	}
+	current := tagRef + "@" + remote.Manifest
+	res.State[key] = tagRef + "@" + remote.recorded()
+
+	prefix := check.EnvName("docker", firstNonEmpty(img.Name, img.Repo))
+	res.Vars[prefix+"_TAG"] = tag
+	res.Vars[prefix+"_DIGEST"] = remote.Manifest
+	res.Vars[prefix+"_IMAGE"] = current
+
+	_, lastManifest := splitRecorded(lastDigests)
+	switch {
+	case !ok:
+		res.Triggered = true
+		res.Logs = append(res.Logs, fmt.Sprintf("%s not deployed yet; selected %s", key, current))
+	case lastRef+"@"+lastManifest != current:
+		res.Triggered = true
+		res.Logs = append(res.Logs, fmt.Sprintf("%s changed %s -> %s", key, lastRef+"@"+lastManifest, current))
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package endpoint implements watchs.http: it polls HTTP endpoints and
+// triggers when the value they publish differs from the last deployed one.
+package endpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+func init() {
+	check.Register("http", func(env check.Env) check.Checker {
+		return &checker{root: env.Root, prev: env.State}
+	})
+}
+
+// Endpoint is one entry of watchs.http, which is either a single mapping or
+// a list of them:
+//
+//	http:
+//	  - name: api
+//	    url: https://releases.internal/api/latest
+//	    headers: {Accept: application/json}
+//	    token_env: RELEASES_TOKEN
+//	    json: $.tag_name
+//	  - url: https://cdn.example.com/app/version.txt
+//	    regex: 'v(\d+\.\d+\.\d+)'
+//
+// Without json or regex the value is the ETag, else Last-Modified, else a
+// SHA-256 of the body.
+type Endpoint struct {
+	// Name keys the value in state.yml and names the script variable; defaults to URL.
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	// Username and a password (inline, from env or from a file) use basic auth.
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordEnv  string `yaml:"password_env"`
+	PasswordFile string `yaml:"password_file"`
+	// Token is sent as "Authorization: Bearer <token>".
+	Token     string `yaml:"token"`
+	TokenEnv  string `yaml:"token_env"`
+	TokenFile string `yaml:"token_file"`
+	// JSON is a JSONPath such as $.release.tag or $.items[0].version.
+	JSON string `yaml:"json"`
+	// Regex extracts its first capture group, or the whole match.
+	Regex string `yaml:"regex"`
+	// Timeout limits each request (default 30s).
+	Timeout time.Duration `yaml:"timeout"`
+	// Insecure skips TLS verification.
+	Insecure bool `yaml:"insecure"`
+	// OnError is fail (default), skip or warn; see check.ParseOnError.
+	OnError string `yaml:"on_error"`
+
+	path  jsonPath
+	regex *regexp.Regexp
+}
+
+type checker struct {
+	root      string
+	prev      map[string]string
+	endpoints []Endpoint
+}
+
+func (c *checker) Name() string { return "http" }
+
+func (c *checker) Decode(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		if err := node.Decode(&c.endpoints); err != nil {
+			return err
+		}
+	} else {
+		var e Endpoint
+		if err := node.Decode(&e); err != nil {
+			return err
+		}
+		c.endpoints = []Endpoint{e}
+	}
+	seen := make(map[string]bool)
+	for i := range c.endpoints {
+		e := &c.endpoints[i]
+		if err := e.init(c.root); err != nil {
+			return err
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("duplicate http entry %q; set a distinct name", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	return nil
+}
+
+func (e *Endpoint) init(root string) error {
+	if e.URL == "" {
+		return errors.New("http entry needs url")
+	}
+	if e.Name == "" {
+		e.Name = e.URL
+	}
+	if e.Method == "" {
+		e.Method = http.MethodGet
+	}
+	if e.Timeout <= 0 {
+		e.Timeout = 30 * time.Second
+	}
+	if e.JSON != "" && e.Regex != "" {
+		return fmt.Errorf("%s: json and regex are mutually exclusive", e.Name)
+	}
+	var err error
+	if e.JSON != "" {
+		if e.path, err = parseJSONPath(e.JSON); err != nil {
+			return fmt.Errorf("%s: %w", e.Name, err)
+		}
+	}
+	if e.Regex != "" {
+		if e.regex, err = regexp.Compile(e.Regex); err != nil {
+			return fmt.Errorf("%s: %w", e.Name, err)
+		}
+	}
+	if e.OnError, err = check.ParseOnError(e.OnError); err != nil {
+		return fmt.Errorf("%s: %w", e.Name, err)
+	}
+	for _, p := range []*string{&e.PasswordFile, &e.TokenFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Clean(filepath.Join(root, *p))
+		}
+	}
+	return nil
+}
+
+func (c *checker) Enabled() bool { return len(c.endpoints) > 0 }
+
+func (c *checker) Check(ctx context.Context) (*check.Result, error) {
+	res := &check.Result{State: make(map[string]string), Vars: make(map[string]string)}
+	for _, e := range c.endpoints {
+		last, seen := c.prev[e.Name]
+		value, err := e.fetch(ctx, last)
+		if err != nil {
+			var keep map[string]string
+			if seen {
+				keep = map[string]string{e.Name: last}
+			}
+			if err := res.Tolerate("http", "["+e.Name+"] ", e.OnError, err, keep); err != nil {
+				return nil, fmt.Errorf("%s: %w", e.Name, err)
+			}
+			continue
+		}
+		res.State[e.Name] = value
+		res.Vars[check.EnvName("http", e.Name, "value")] = value
+		switch {
+		case !seen:
+			res.Triggered = true
+			res.Logs = append(res.Logs, fmt.Sprintf("%s not deployed yet; value %s", e.Name, value))
+		case last != value:
+			res.Triggered = true
+			res.Logs = append(res.Logs, fmt.Sprintf("%s changed %s -> %s", e.Name, last, value))
+		default:
+			res.Logs = append(res.Logs, fmt.Sprintf("%s no change", e.Name))
+		}
+	}
+	return res, nil
+}
+
+// fetch requests the endpoint and returns its current value. For ETag and
+// Last-Modified values a conditional request is made, and last is returned
+// when the server answers 304 Not Modified.
+func (e Endpoint) fetch(ctx context.Context, last string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, e.Method, e.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	if err := e.authorize(req); err != nil {
+		return "", err
+	}
+	conditional := e.JSON == "" && e.Regex == ""
+	if conditional {
+		if v, ok := strings.CutPrefix(last, "etag:"); ok {
+			req.Header.Set("If-None-Match", v)
+		} else if v, ok := strings.CutPrefix(last, "last-modified:"); ok {
+			req.Header.Set("If-Modified-Since", v)
+		}
+	}
+
+	client := &http.Client{}
+	if e.Insecure {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = tr
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && conditional && last != "" {
+		return last, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	switch {
+	case e.JSON != "" || e.Regex != "":
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		if e.JSON != "" {
+			return e.path.extract(body)
+		}
+		m := e.regex.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("regex %q does not match the response", e.Regex)
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	case resp.Header.Get("ETag") != "":
+		return "etag:" + resp.Header.Get("ETag"), nil
+	case resp.Header.Get("Last-Modified") != "":
+		return "last-modified:" + resp.Header.Get("Last-Modified"), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (e Endpoint) authorize(req *http.Request) error {
+	token, err := check.Secret(e.Token, e.TokenEnv, e.TokenFile)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	password, err := check.Secret(e.Password, e.PasswordEnv, e.PasswordFile)
+	if err != nil {
+		return err
+	}
+	if e.Username != "" || password != "" {
+		req.SetBasicAuth(e.Username, password)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is the subset of JSONPath used to pick a value from a response:
+// $.a.b, $.items[0].version, $['key with.dots'] and negative indexes such
+// as $.releases[-1].
+type jsonPath []step
+
+type step struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func parseJSONPath(s string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), "$")
+	if !ok {
+		return nil, fmt.Errorf("json path %q must start with $", s)
+	}
+	var p jsonPath
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("json path %q: empty key", s)
+			}
+			p = append(p, step{key: rest[:end], isKey: true})
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: unterminated ['", s)
+			}
+			p = append(p, step{key: rest[2:end], isKey: true})
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: unterminated [", s)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("json path %q: invalid index %q", s, rest[1:end])
+			}
+			p = append(p, step{index: i})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", s, rest)
+		}
+	}
+	return p, nil
+}
+
+func (p jsonPath) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, s := range p {
+		if s.isKey {
+			b.WriteString("." + s.key)
+		} else {
+			fmt.Fprintf(&b, "[%d]", s.index)
+		}
+	}
+	return b.String()
+}
+
+// extract decodes body and returns the value at p. Strings are returned as
+// is; numbers, booleans, objects and arrays as their JSON encoding.
+func (p jsonPath) extract(body []byte) (string, error) {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("decode json: %w", err)
+	}
+	for i, s := range p {
+		at := p[:i+1].String()
+		if s.isKey {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("%s: not an object", at)
+			}
+			if v, ok = m[s.key]; !ok {
+				return "", fmt.Errorf("%s: not found", at)
+			}
+			continue
+		}
+		a, ok := v.([]any)
+		if !ok {
+			return "", fmt.Errorf("%s: not an array", at)
+		}
+		idx := s.index
+		if idx < 0 {
+			idx += len(a)
+		}
+		if idx < 0 || idx >= len(a) {
+			return "", fmt.Errorf("%s: index out of range (length %d)", at, len(a))
+		}
+		v = a[idx]
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("%s: value is null", p)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
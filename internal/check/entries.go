@@ -0,0 +1,127 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Entry 为列表形式配置（如 watchs.git、watchs.files、watchs.s3）中的一个条目
+type Entry struct {
+	// Name 为条目名称，列表形式下用作日志前缀、state.yml 键前缀及变量名的一部分
+	Name string
+	// OnError 为该条目的 on_error 配置，见 ParseOnError
+	OnError string
+	// Check 检测该条目；prev 为该条目上次记录的值（已去掉键前缀）
+	// 出错时可同时返回已产生的日志
+	Check func(ctx context.Context, prev map[string]string) (*Result, error)
+}
+
+// CheckEntries 依次检测 entries 并合并结果，kind 为监听类型名称
+// list 为 true 时：日志与动作描述加 [name] 前缀，state.yml 中的键加 name: 前缀，
+// 变量名由 DG_<KIND>_X 改为 DG_<KIND>_<NAME>_X
+// 条目检测失败时按其 OnError 处理：fail 中止，skip/warn 记录日志并沿用上次记录的值
+func CheckEntries(ctx context.Context, kind string, list bool, prev map[string]string, entries []Entry) (*Result, error) {
+	res := &Result{Logs: []string{}}
+	for _, e := range entries {
+		prefix, label := "", ""
+		if list {
+			prefix, label = e.Name+":", "["+e.Name+"] "
+		}
+		last := make(map[string]string)
+		for k, v := range prev {
+			if strings.HasPrefix(k, prefix) {
+				last[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+		r, err := e.Check(ctx, last)
+		if err != nil {
+			// 保留失败前已产生的日志，标签在下面合并时统一加上
+			var logs []string
+			if r != nil {
+				logs = r.Logs
+			}
+			r = &Result{Logs: logs}
+			if err := r.Tolerate(kind, "", e.OnError, err, last); err != nil {
+				if list {
+					return nil, fmt.Errorf("%s: %w", e.Name, err)
+				}
+				return nil, err
+			}
+		}
+		res.Triggered = res.Triggered || r.Triggered
+		for _, l := range r.Logs {
+			res.Logs = append(res.Logs, label+l)
+		}
+		for _, w := range r.Warnings {
+			res.Warnings = append(res.Warnings, label+w)
+		}
+		for k, v := range r.Vars {
+			if res.Vars == nil {
+				res.Vars = make(map[string]string)
+			}
+			// 列表条目的变量名加上条目名称，如 DG_GIT_BRANCH -> DG_GIT_INFRA_BRANCH
+			if list {
+				k = EnvName(kind, e.Name, strings.TrimPrefix(k, EnvName(kind)+"_"))
+			}
+			res.Vars[k] = v
+		}
+		for _, a := range r.Actions {
+			a.Desc = label + a.Desc
+			res.Actions = append(res.Actions, a)
+		}
+		if r.State != nil {
+			if res.State == nil {
+				res.State = make(map[string]string)
+			}
+			for k, v := range r.State {
+				res.State[prefix+k] = v
+			}
+		}
+	}
+	return res, nil
+}
+
+// Tolerate 按 onError 处理一个条目的检测失败：fail 时原样返回 err；
+// skip/warn 时记录日志（warn 同时记入 Warnings）并返回 nil，
+// 同时将 last 写回 State，使该条目不触发，下次仍与上次记录的值对比
+// label 为日志与警告的前缀，如 "[api] "
+func (r *Result) Tolerate(kind, label, onError string, err error, last map[string]string) error {
+	if onError == OnErrorFail {
+		return err
+	}
+	r.Logs = append(r.Logs, fmt.Sprintf("%s%s check failed, skipped: %v", label, kind, err))
+	if onError == OnErrorWarn {
+		r.Warnings = append(r.Warnings, label+err.Error())
+	}
+	if len(last) > 0 {
+		if r.State == nil {
+			r.State = make(map[string]string)
+		}
+		for k, v := range last {
+			r.State[k] = v
+		}
+	}
+	return nil
+}
+
+// MaxLoggedItems 限制日志中列出的文件、提交、对象等的数量
+const MaxLoggedItems = 20
+
+// FormatList 拼接列表，超出 MaxLoggedItems 的部分只记录数量
+func FormatList(items []string) string {
+	if len(items) <= MaxLoggedItems {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s, ... (%d more)", strings.Join(items[:MaxLoggedItems], ", "), len(items)-MaxLoggedItems)
+}
+
+// Contains 报告 list 中是否包含 s
+func Contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
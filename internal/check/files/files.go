@@ -0,0 +1,320 @@
+// Package files implements watchs.files: it keeps a manifest of local files
+// matching globs in state.yml and triggers when files are added, changed or
+// removed, e.g. when CI drops new build artifacts into a directory.
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+func init() {
+	check.Register("files", func(env check.Env) check.Checker {
+		return &checker{root: env.Root, prev: env.State}
+	})
+}
+
+// Modes select what is recorded for each file.
+const (
+	// ModeHash records a SHA-256 of the content.
+	ModeHash = "hash"
+	// ModeStat records size and modification time, without reading files.
+	ModeStat = "stat"
+)
+
+// Config is watchs.files, or one entry when it is a list:
+//
+//	files:
+//	  paths: [/srv/drops/app/**/*.tar.gz]
+//	  exclude: ["*.part"]
+//	  settle: 30s
+type Config struct {
+	// Name prefixes log lines and state keys in list form; defaults to the first path.
+	Name string `yaml:"name"`
+	// Paths are files, directories (watched recursively) or globs; relative
+	// paths are resolved against config.yml's directory.
+	Paths []string `yaml:"paths"`
+	// Exclude drops matching files. Patterns without a slash match the base
+	// name, others the full path.
+	Exclude []string `yaml:"exclude"`
+	// Mode is hash (default) or stat.
+	Mode string `yaml:"mode"`
+	// Settle skips files modified within this duration, as they may still
+	// be being written.
+	Settle time.Duration `yaml:"settle"`
+	// OnError is fail (default), skip or warn; see check.ParseOnError.
+	OnError string `yaml:"on_error"`
+
+	globs   []glob
+	exclude []*regexp.Regexp
+}
+
+// glob is a compiled entry of Paths: the directory walked and the regexp
+// its files must match.
+type glob struct {
+	raw  string
+	base string
+	re   *regexp.Regexp
+}
+
+type checker struct {
+	root    string
+	prev    map[string]string
+	entries []Config
+	// list is true when watchs.files is a list; state keys and log lines
+	// are then prefixed with the entry name.
+	list bool
+}
+
+func (c *checker) Name() string { return "files" }
+
+func (c *checker) Decode(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var cfg Config
+		if err := node.Decode(&cfg); err != nil {
+			return err
+		}
+		if err := cfg.init(c.root); err != nil {
+			return err
+		}
+		c.entries = []Config{cfg}
+		return nil
+	}
+	if err := node.Decode(&c.entries); err != nil {
+		return err
+	}
+	c.list = true
+	seen := make(map[string]bool)
+	for i := range c.entries {
+		cfg := &c.entries[i]
+		if err := cfg.init(c.root); err != nil {
+			return err
+		}
+		if cfg.Name == "" {
+			cfg.Name = cfg.Paths[0]
+		}
+		if seen[cfg.Name] {
+			return fmt.Errorf("duplicate files entry %q; set a distinct name", cfg.Name)
+		}
+		seen[cfg.Name] = true
+	}
+	return nil
+}
+
+func (cfg *Config) init(root string) error {
+	if len(cfg.Paths) == 0 {
+		return errors.New("files entry needs paths")
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ModeHash
+	case ModeHash, ModeStat:
+	default:
+		return fmt.Errorf("mode must be %s or %s, got %q", ModeHash, ModeStat, cfg.Mode)
+	}
+	if cfg.Settle < 0 {
+		return fmt.Errorf("settle must not be negative, got %s", cfg.Settle)
+	}
+	var err error
+	if cfg.OnError, err = check.ParseOnError(cfg.OnError); err != nil {
+		return err
+	}
+	for _, p := range cfg.Paths {
+		g, err := compileGlob(root, p)
+		if err != nil {
+			return err
+		}
+		cfg.globs = append(cfg.globs, g)
+	}
+	for _, p := range cfg.Exclude {
+		expr := check.GlobToRegexp(p)
+		if !strings.Contains(p, "/") {
+			expr = "(?:^|/)" + expr
+		} else {
+			if !filepath.IsAbs(p) && !strings.HasPrefix(p, "**") {
+				expr = check.GlobToRegexp(filepath.ToSlash(filepath.Join(root, p)))
+			}
+			expr = "^" + expr
+		}
+		re, err := regexp.Compile(expr + "$")
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+		cfg.exclude = append(cfg.exclude, re)
+	}
+	return nil
+}
+
+// compileGlob resolves p against root and splits it into the longest
+// directory without glob characters, which is walked, and a regexp for the
+// whole path. A plain path matches the file itself or every file below it.
+func compileGlob(root, p string) (glob, error) {
+	full := filepath.ToSlash(filepath.Clean(p))
+	if !filepath.IsAbs(p) {
+		full = filepath.ToSlash(filepath.Join(root, p))
+	}
+	if !strings.ContainsAny(full, "*?[") {
+		re := regexp.MustCompile("^" + regexp.QuoteMeta(full) + "(?:/.*)?$")
+		return glob{raw: p, base: filepath.FromSlash(full), re: re}, nil
+	}
+	parts := strings.Split(full, "/")
+	n := 0
+	for !strings.ContainsAny(parts[n], "*?[") {
+		n++
+	}
+	base := strings.Join(parts[:n], "/")
+	if base == "" {
+		base = "/"
+	}
+	re, err := regexp.Compile("^" + check.GlobToRegexp(full) + "$")
+	if err != nil {
+		return glob{}, fmt.Errorf("invalid path pattern %q: %w", p, err)
+	}
+	return glob{raw: p, base: filepath.FromSlash(base), re: re}, nil
+}
+
+func (c *checker) Enabled() bool { return len(c.entries) > 0 }
+
+func (c *checker) Check(ctx context.Context) (*check.Result, error) {
+	entries := make([]check.Entry, len(c.entries))
+	for i, cfg := range c.entries {
+		entries[i] = check.Entry{Name: cfg.Name, OnError: cfg.OnError, Check: cfg.check}
+	}
+	return check.CheckEntries(ctx, "files", c.list, c.prev, entries)
+}
+
+// check builds the manifest of the entry's files and compares it with prev,
+// the manifest recorded after the last successful run.
+func (cfg Config) check(ctx context.Context, prev map[string]string) (*check.Result, error) {
+	res := &check.Result{State: make(map[string]string), Vars: make(map[string]string)}
+	now := time.Now()
+	var unsettled []string
+	for _, g := range cfg.globs {
+		err := filepath.WalkDir(g.base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == g.base && errors.Is(err, fs.ErrNotExist) && !strings.ContainsAny(g.raw, "*?[") {
+					// a plain path that does not exist (yet)
+					return fs.SkipAll
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			slash := filepath.ToSlash(path)
+			if d.IsDir() || !g.re.MatchString(slash) || cfg.excluded(slash) {
+				return nil
+			}
+			if _, ok := res.State[path]; ok {
+				return nil
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					// removed while walking, or a dangling symlink
+					return nil
+				}
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			if cfg.Settle > 0 && now.Sub(fi.ModTime()) < cfg.Settle {
+				unsettled = append(unsettled, path)
+				if last, ok := prev[path]; ok {
+					res.State[path] = last
+				}
+				return nil
+			}
+			v, err := cfg.fingerprint(path, fi)
+			if err != nil {
+				return err
+			}
+			res.State[path] = v
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", g.raw, err)
+		}
+	}
+
+	var added, changed, removed []string
+	for path, v := range res.State {
+		last, ok := prev[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case last != v:
+			changed = append(changed, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := res.State[path]; !ok && !check.Contains(unsettled, path) {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	sort.Strings(unsettled)
+
+	if len(unsettled) > 0 {
+		res.Logs = append(res.Logs, fmt.Sprintf("files still being written, skipped: %s", check.FormatList(unsettled)))
+	}
+	for _, l := range []struct {
+		kind  string
+		paths []string
+	}{{"added", added}, {"changed", changed}, {"removed", removed}} {
+		if len(l.paths) > 0 {
+			res.Triggered = true
+			res.Logs = append(res.Logs, fmt.Sprintf("files %s: %s", l.kind, check.FormatList(l.paths)))
+		}
+		res.Vars[check.EnvName("files", l.kind)] = strings.Join(l.paths, "\n")
+	}
+	if !res.Triggered {
+		res.Logs = append(res.Logs, fmt.Sprintf("files no change; %d watched", len(res.State)))
+	}
+	return res, nil
+}
+
+func (cfg Config) excluded(path string) bool {
+	for _, re := range cfg.exclude {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprint returns the manifest value of a file: "sha256:<hex>" in hash
+// mode, "<size> <mtime>" in stat mode.
+func (cfg Config) fingerprint(path string, fi fs.FileInfo) (string, error) {
+	if cfg.Mode == ModeStat {
+		return fmt.Sprintf("%d %s", fi.Size(), fi.ModTime().UTC().Format(time.RFC3339Nano)), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
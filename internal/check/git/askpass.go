@@ -0,0 +1,110 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dg 自身充当 git 的 GIT_ASKPASS 程序：凭据通过子进程环境变量传递，
+// 不会出现在命令行参数 (ps) 或 URL 中
+const (
+	askpassEnv         = "DG_GIT_ASKPASS"
+	askpassUsernameEnv = "DG_GIT_ASKPASS_USERNAME"
+	askpassPasswordEnv = "DG_GIT_ASKPASS_PASSWORD"
+)
+
+// ServeAskpass 在 dg 被 git 作为 GIT_ASKPASS 调用时输出所需的凭据并返回 true；
+// 普通调用时返回 false。git 以提示语作为唯一参数，如 "Username for 'https://host': "
+func ServeAskpass(args []string) bool {
+	if os.Getenv(askpassEnv) != "1" {
+		return false
+	}
+	prompt := ""
+	if len(args) > 1 {
+		prompt = args[1]
+	}
+	if strings.HasPrefix(prompt, "Username") {
+		fmt.Println(os.Getenv(askpassUsernameEnv))
+	} else {
+		fmt.Println(os.Getenv(askpassPasswordEnv))
+	}
+	return true
+}
+
+// gitCmd 携带执行 git 命令所需的额外配置与环境变量，以及输出中需要脱敏的机密
+type gitCmd struct {
+	config  []string
+	env     []string
+	secrets []string
+}
+
+// withCredentials 通过 askpass 提供 HTTPS 凭据，并清空已配置的 credential.helper，
+// 避免凭据被 git 写入用户的凭据存储
+func (g gitCmd) withCredentials(username, password string) (gitCmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return g, fmt.Errorf("locate dg executable for askpass: %w", err)
+	}
+	g.env = append(g.env,
+		"GIT_ASKPASS="+exe,
+		askpassEnv+"=1",
+		askpassUsernameEnv+"="+username,
+		askpassPasswordEnv+"="+password,
+	)
+	g.config = append(g.config, "credential.helper=")
+	g.secrets = append(g.secrets, password)
+	return g, nil
+}
+
+func (g gitCmd) run(ctx context.Context, dir string, args ...string) (string, error) {
+	var full []string
+	for _, c := range g.config {
+		full = append(full, "-c", c)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(full, args...)...)
+	cmd.Dir = dir
+	// 禁用交互式提示，防止卡死
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, g.env...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", &Error{
+			Op:   args[0],
+			Kind: classify(ctx, string(out)),
+			Err:  errors.New(g.redact(fmt.Sprintf("%v, output: %s", err, strings.TrimSpace(string(out))))),
+		}
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// netTimeout 为单条访问远端的 git 命令（ls-remote、fetch）的超时
+const netTimeout = 30 * time.Second
+
+// runNet 与 run 相同，但每条命令单独限时 netTimeout，
+// 避免多个分支依次 fetch 时共用同一个超时
+func (g gitCmd) runNet(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, netTimeout)
+	defer cancel()
+	return g.run(ctx, dir, args...)
+}
+
+// userinfoRe 匹配 URL 中的 user:password@ 部分
+var userinfoRe = regexp.MustCompile(`(://)[^/@\s]+@`)
+
+// redact 去除 s 中 URL 内嵌的凭据以及已知的机密
+func (g gitCmd) redact(s string) string {
+	s = userinfoRe.ReplaceAllString(s, "${1}***@")
+	for _, secret := range g.secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "***")
+		}
+	}
+	return s
+}
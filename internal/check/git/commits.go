@@ -0,0 +1,126 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+// CommitRule 对应 watchs.git.commits 中的一项，对新提交的信息与作者进行过滤：
+//
+//	commits:
+//	  - message: {exclude: ["[skip deploy]"]}
+//	    author: {exclude: ["deploy-bot"]}
+//	  - branches: [production]
+//	    message: {include: ["[deploy]"]}
+//
+// 分支只有在新提交中至少有一个通过所有适用规则时才触发
+type CommitRule struct {
+	// Branches 非空时规则仅作用于这些分支 (glob)
+	Branches []string `yaml:"branches"`
+	// Message 匹配完整的提交信息
+	Message TextRules `yaml:"message"`
+	// Author 匹配 "Name <email>"
+	Author TextRules `yaml:"author"`
+
+	branches filter
+}
+
+// TextRules 为自由文本的 include/exclude 规则，普通字符串按子串匹配，以 / 包裹时为正则
+type TextRules struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	filter filter
+}
+
+func (r *TextRules) UnmarshalYAML(node *yaml.Node) error {
+	type plain TextRules
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	var err error
+	r.filter, err = newTextFilter(r.Include, r.Exclude)
+	return err
+}
+
+func (r *CommitRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain CommitRule
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	var err error
+	r.branches, err = newFilter(r.Branches, nil)
+	return err
+}
+
+type commit struct {
+	sha     string
+	author  string
+	message string
+}
+
+// subject 返回提交信息的首行
+func (c commit) subject() string {
+	s, _, _ := strings.Cut(c.message, "\n")
+	return s
+}
+
+// listCommits 返回 base..head 范围内的提交，base 为空时只返回 head 提交；
+// 需要 head 已拉取到本地
+func listCommits(ctx context.Context, g gitCmd, repoPath, base, head string) ([]commit, error) {
+	rev := []string{"-1", head}
+	if base != "" {
+		if _, err := g.run(ctx, repoPath, "cat-file", "-e", base+"^{commit}"); err != nil {
+			return nil, fmt.Errorf("commit %s not available locally", shortSHA(base))
+		}
+		rev = []string{base + ".." + head}
+	}
+	out, err := g.run(ctx, repoPath, append([]string{"log", "--format=%H%x1f%an <%ae>%x1f%B%x1e"}, rev...)...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commit
+	for _, rec := range strings.Split(out, "\x1e") {
+		parts := strings.SplitN(strings.TrimSpace(rec), "\x1f", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		commits = append(commits, commit{sha: parts[0], author: parts[1], message: strings.TrimSpace(parts[2])})
+	}
+	return commits, nil
+}
+
+// matchCommits 返回通过所有适用于 branch 的规则的提交
+func matchCommits(commits []commit, branch string, rules []CommitRule) []commit {
+	var matched []commit
+	for _, c := range commits {
+		ok := true
+		for _, r := range rules {
+			if !r.branches.match(branch) {
+				continue
+			}
+			if !r.Message.filter.match(c.message) || !r.Author.filter.match(c.author) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// formatCommits 以 "短 SHA 标题" 的形式拼接提交
+func formatCommits(commits []commit) string {
+	items := make([]string, len(commits))
+	for i, c := range commits {
+		items[i] = shortSHA(c.sha) + " " + c.subject()
+	}
+	return check.FormatList(items)
+}
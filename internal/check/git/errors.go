@@ -0,0 +1,55 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// git 检测失败的类别，可用 errors.Is 判断
+var (
+	ErrRepoNotFound = errors.New("repository not found")
+	ErrNoRemote     = errors.New("remote not found")
+	ErrAuth         = errors.New("authentication failed")
+	ErrTimeout      = errors.New("timed out")
+	ErrCommand      = errors.New("command failed")
+)
+
+// Error 描述一次失败的 git 操作，Kind 为上面的类别之一
+type Error struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("git %s: %v: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// authFailures 为 git / ssh 输出中表示认证失败的片段
+var authFailures = []string{
+	"Authentication failed",
+	"could not read Username",
+	"could not read Password",
+	"Permission denied (publickey",
+	"Host key verification failed",
+	"HTTP Basic: Access denied",
+	"The requested URL returned error: 401",
+	"The requested URL returned error: 403",
+}
+
+// classify 根据上下文与命令输出判断失败类别
+func classify(ctx context.Context, output string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	for _, s := range authFailures {
+		if strings.Contains(output, s) {
+			return ErrAuth
+		}
+	}
+	return ErrCommand
+}
@@ -2,127 +2,483 @@ package git
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
+
+	"dg/internal/check"
+
+	"gopkg.in/yaml.v3"
 )
 
-// Config 定义 Git 检测的配置参数
-// 调用方需将 internal/config 的 GitConfig 映射到此结构
-type Config struct {
-	Remote   string
-	Username string
-	Password string
-	Branches []string
-	Tags     bool
+func init() {
+	check.Register("git", func(env check.Env) check.Checker {
+		return &checker{root: env.Root, prev: env.State}
+	})
 }
 
-// Result 保存检测结果
-type Result struct {
-	Triggered bool
-	Logs      []string
+// Config 定义 Git 检测的配置参数，对应 watchs.git 或其列表中的一项
+type Config struct {
+	// Name 为列表条目的名称，用于 state.yml 键与日志前缀，默认取 Path
+	Name string `yaml:"name"`
+	// Path 为仓库目录，默认从 config.yml 所在目录向上查找
+	Path string `yaml:"path"`
+	// URL 为远端仓库地址；设置后不需要本地克隆，直接 ls-remote 并与 state.yml 对比
+	URL      string `yaml:"url"`
+	Remote   string `yaml:"remote"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordEnv / PasswordFile 从环境变量或文件 (相对 config.yml 所在目录) 读取密码
+	PasswordEnv  string `yaml:"password_env"`
+	PasswordFile string `yaml:"password_file"`
+	// SSH 为 SSH 远端指定部署密钥与主机密钥校验方式
+	SSH      SSHConfig  `yaml:"ssh"`
+	Branches []string   `yaml:"branches"`
+	Tags     TagsConfig `yaml:"tags"`
+	// Paths 非空时，分支变化仅在变更文件匹配其中规则时触发 (如 services/api/**)
+	Paths []string `yaml:"paths"`
+	// Commits 非空时，分支变化仅在新提交通过信息/作者规则时触发
+	Commits []CommitRule `yaml:"commits"`
+	// Verify 配置后，新的分支头提交与标签必须带有受信任的签名才会触发
+	Verify VerifyConfig `yaml:"verify_signatures"`
+	// Sync 为 fetch、checkout 或 reset 时，触发后在执行脚本前同步本地仓库
+	Sync string `yaml:"sync"`
+	// OnError 为检测失败时的处理方式：fail (默认)、skip 或 warn，见 check.ParseOnError
+	OnError string `yaml:"on_error"`
+	// Compare 为 local 或 deployed，默认 local
+	Compare string `yaml:"compare"`
+
+	paths    filter
+	branches []pattern
 }
 
-// Check 执行 Git 状态检测
-func Check(ctx context.Context, cfgDir string, cfg Config) (*Result, error) {
-	res := &Result{Logs: []string{}}
+// TagsConfig 对应 watchs.git.tags，可写为 true，或写为映射：
+//
+//	tags:
+//	  include: ["v*"]
+//	  exclude: ["*-rc*", "/^v0\\./"]
+//	  moved: true
+//
+// 规则为 glob，以 / 包裹时为正则；include 为空时包含全部标签
+type TagsConfig struct {
+	Enabled bool     `yaml:"-"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Moved 为 true 时，已有标签指向了新的对象也会触发
+	Moved bool `yaml:"moved"`
+
+	filter filter
+}
 
-	// 1. 定位仓库
-	repoPath, err := findRepoPath(cfgDir)
+func (t *TagsConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&t.Enabled)
+	}
+	type plain TagsConfig
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	t.Enabled = true
+	f, err := newFilter(t.Include, t.Exclude)
 	if err != nil {
-		res.Logs = append(res.Logs, fmt.Sprintf("git repo not found: %v", err))
-		return res, nil // 仓库未找到不视为错误，只是跳过检测
+		return fmt.Errorf("tags: %w", err)
 	}
+	t.filter = f
+	return nil
+}
+
+// checker 将 Check 适配为 check.Checker
+// watchs.git 可以是单个映射，也可以是多个仓库条目组成的列表
+type checker struct {
+	root  string
+	prev  map[string]string
+	repos []Config
+	// list 为 true 时，state.yml 中的键与日志以条目名称为前缀
+	list bool
+}
 
-	// 2. 确定远端名称
+func (c *checker) Name() string { return "git" }
+
+func (c *checker) Decode(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var cfg Config
+		if err := node.Decode(&cfg); err != nil {
+			return err
+		}
+		if err := cfg.init(c.root); err != nil {
+			return err
+		}
+		c.repos = []Config{cfg}
+		return nil
+	}
+	if err := node.Decode(&c.repos); err != nil {
+		return err
+	}
+	c.list = true
+	seen := make(map[string]bool)
+	for i := range c.repos {
+		cfg := &c.repos[i]
+		if cfg.Name == "" {
+			cfg.Name = cfg.Path
+		}
+		if cfg.Name == "" {
+			cfg.Name = gitCmd{}.redact(cfg.URL)
+		}
+		if cfg.Name == "" {
+			cfg.Name = "."
+		}
+		if seen[cfg.Name] {
+			return fmt.Errorf("duplicate git entry %q; set a distinct name", cfg.Name)
+		}
+		seen[cfg.Name] = true
+		if err := cfg.init(c.root); err != nil {
+			return fmt.Errorf("%s: %w", cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// init 校验配置并编译匹配规则
+func (cfg *Config) init(root string) error {
+	var err error
+	if cfg.PasswordFile != "" && !filepath.IsAbs(cfg.PasswordFile) {
+		cfg.PasswordFile = filepath.Join(root, cfg.PasswordFile)
+	}
+	if err := cfg.SSH.init(root); err != nil {
+		return err
+	}
+	if err := cfg.Verify.init(root); err != nil {
+		return err
+	}
+	if err := parseSync(cfg.Sync); err != nil {
+		return err
+	}
+	if cfg.OnError, err = check.ParseOnError(cfg.OnError); err != nil {
+		return err
+	}
+	if cfg.paths, err = newFilter(cfg.Paths, nil); err != nil {
+		return fmt.Errorf("paths: %w", err)
+	}
+	cfg.branches = nil
+	for _, b := range cfg.Branches {
+		p, err := compilePattern(b)
+		if err != nil {
+			return fmt.Errorf("branches: %w", err)
+		}
+		cfg.branches = append(cfg.branches, p)
+	}
+	if cfg.URL != "" {
+		// 没有本地仓库可对比，只能与上次部署记录对比
+		if cfg.Path != "" || cfg.Remote != "" {
+			return errors.New("url cannot be combined with path or remote")
+		}
+		if len(cfg.Paths) > 0 || len(cfg.Commits) > 0 || cfg.Verify.enabled() || cfg.Sync != "" {
+			return errors.New("paths, commits, verify_signatures and sync need a local clone and cannot be used with url")
+		}
+		if cfg.Compare == "" {
+			cfg.Compare = check.CompareDeployed
+		}
+		if cfg.Compare != check.CompareDeployed {
+			return fmt.Errorf("url requires compare: %s", check.CompareDeployed)
+		}
+	}
+	if cfg.Compare, err = check.ParseCompare(cfg.Compare); err != nil {
+		return err
+	}
+	if cfg.Sync == SyncFetch && cfg.Compare == check.CompareLocal && len(cfg.Branches) > 0 {
+		// fetch 只更新 refs/remotes/，本地分支不变，同一变化会在每次运行时重复触发
+		return fmt.Errorf("sync: %s leaves local branches unchanged; use compare: %s, or sync: %s or %s",
+			SyncFetch, check.CompareDeployed, SyncCheckout, SyncReset)
+	}
+	return nil
+}
+
+func (c *checker) Enabled() bool {
+	for _, cfg := range c.repos {
+		if len(cfg.Branches) > 0 || cfg.Tags.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) Check(ctx context.Context) (*check.Result, error) {
+	entries := make([]check.Entry, len(c.repos))
+	for i, cfg := range c.repos {
+		cfg := cfg
+		entries[i] = check.Entry{
+			Name:    cfg.Name,
+			OnError: cfg.OnError,
+			Check: func(ctx context.Context, prev map[string]string) (*check.Result, error) {
+				return Check(ctx, c.repoDir(cfg), cfg, prev)
+			},
+		}
+	}
+	return check.CheckEntries(ctx, "git", c.list, c.prev, entries)
+}
+
+// repoDir 返回条目对应的仓库目录，path 为相对路径时相对 config.yml 所在目录
+func (c *checker) repoDir(cfg Config) string {
+	if cfg.Path == "" {
+		return c.root
+	}
+	if filepath.IsAbs(cfg.Path) {
+		return cfg.Path
+	}
+	return filepath.Join(c.root, cfg.Path)
+}
+
+// Check 执行 Git 状态检测
+// prev 为上次成功部署记录的 ref -> SHA，仅在 deployed 模式下使用
+// 检测失败时返回 *Error，此时 res 仅包含出错前的日志
+func Check(ctx context.Context, cfgDir string, cfg Config, prev map[string]string) (*check.Result, error) {
+	res := &check.Result{Logs: []string{}}
+	// reject 记录未通过签名校验（或无法校验）的变更：不触发，运行以 warning 结束
+	reject := func(msg string) {
+		res.Logs = append(res.Logs, msg)
+		res.Warnings = append(res.Warnings, msg)
+	}
+	deployed := cfg.Compare == check.CompareDeployed
+	// deployed 模式下记录本次观察到的 SHA，未检测到的条目沿用旧值
+	next := make(map[string]string)
+	if deployed {
+		for k, v := range prev {
+			if cfg.Tags.Enabled && strings.HasPrefix(k, "refs/tags/") {
+				continue // 标签集合以远端为准整体替换
+			}
+			next[k] = v
+		}
+	}
+
+	// 1. 定位仓库；配置了 url 时无需本地仓库，git 命令在 config.yml 所在目录执行
+	var g gitCmd
+	if sshCmd := cfg.SSH.command(); sshCmd != "" {
+		g.env = append(g.env, "GIT_SSH_COMMAND="+sshCmd)
+	}
+	repoPath, remoteURL := cfgDir, cfg.URL
 	remoteName := cfg.Remote
-	if remoteName == "" {
-		remoteName = "origin"
+	if remoteURL == "" {
+		var err error
+		repoPath, err = findRepoPath(cfgDir)
+		if err != nil {
+			return res, &Error{Op: "find repository", Kind: ErrRepoNotFound, Err: err}
+		}
+
+		// 2. 确定远端名称
+		if remoteName == "" {
+			remoteName = "origin"
+		}
 	}
 
 	// 3. 获取基础远端 URL (使用系统 git 配置)
-	// 设置网络操作超时
-	netCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+	// 访问远端的命令各自限时，见 runNet
+	if remoteURL == "" {
+		var err error
+		remoteURL, err = g.run(ctx, repoPath, "remote", "get-url", remoteName)
+		if err != nil {
+			var ge *Error
+			if errors.As(err, &ge) && ge.Kind == ErrCommand {
+				ge.Kind = ErrNoRemote
+			}
+			return res, err
+		}
+	}
 
-	rawURL, err := runGitCmd(netCtx, repoPath, "remote", "get-url", remoteName)
+	// 4. 如果配置了 HTTPS 凭据，通过 askpass 提供给 git
+	password, err := check.Secret(cfg.Password, cfg.PasswordEnv, cfg.PasswordFile)
 	if err != nil {
-		res.Logs = append(res.Logs, fmt.Sprintf("failed to get remote url for '%s': %v", remoteName, err))
-		return res, nil
+		return res, &Error{Op: "read password", Kind: ErrAuth, Err: err}
 	}
-
-	// 4. 如果配置了 HTTPS 凭据，构造带认证的 URL
-	remoteURL := rawURL
-	if cfg.Username != "" && cfg.Password != "" && strings.HasPrefix(rawURL, "http") {
-		remoteURL = buildAuthURL(rawURL, cfg.Username, cfg.Password)
+	if cfg.Username != "" && password != "" && strings.HasPrefix(remoteURL, "http") {
+		if g, err = g.withCredentials(cfg.Username, password); err != nil {
+			return res, &Error{Op: "askpass", Kind: ErrCommand, Err: err}
+		}
 	}
 
 	// 5. 检测分支更新
+	// target 记录触发的分支或标签，作为 sync 的目标
+	var target syncTarget
+	var triggered, deleted []string
 	if len(cfg.Branches) > 0 {
 		// 获取远端分支 Heads
-		// git ls-remote --heads <url> branch1 branch2 ...
+		// git ls-remote --heads <url> branch1 branch2 ...，含通配符时列出全部分支再匹配
 		args := []string{"ls-remote", "--heads", remoteURL}
-		args = append(args, cfg.Branches...)
-		out, err := runGitCmd(netCtx, repoPath, args...)
+		if !cfg.hasBranchPattern() {
+			args = append(args, cfg.Branches...)
+		}
+		out, err := g.runNet(ctx, repoPath, args...)
 		if err != nil {
-			res.Logs = append(res.Logs, fmt.Sprintf("git ls-remote heads error: %v", err))
-			return res, nil
+			return res, err
 		}
 		remoteHeads := parseRemoteRefs(out, "refs/heads/")
 
-		for _, branch := range cfg.Branches {
-			remoteSHA, remoteExists := remoteHeads[branch]
-			if !remoteExists {
-				continue // 远端不存在该分支，跳过
+		// deployed 模式下，上次记录过但远端已不存在的匹配分支视为删除
+		if deployed {
+			for k := range prev {
+				branch := strings.TrimPrefix(k, "refs/heads/")
+				if branch == k || !cfg.matchBranch(branch) {
+					continue
+				}
+				if _, ok := remoteHeads[branch]; !ok {
+					deleted = append(deleted, branch)
+					delete(next, k)
+				}
 			}
-
-			// 获取本地 SHA
-			localSHA, err := getLocalSHA(ctx, repoPath, "refs/heads/"+branch)
-			if err != nil {
-				// 本地获取失败通常意味着本地没有该分支
-				localSHA = "missing"
+			sort.Strings(deleted)
+			if len(deleted) > 0 {
+				res.Triggered = true
+				res.Logs = append(res.Logs, fmt.Sprintf("git deleted branches: %s", strings.Join(deleted, ", ")))
 			}
+		}
 
-			if localSHA != remoteSHA {
+		for _, branch := range cfg.expandBranches(remoteHeads) {
+			remoteSHA := remoteHeads[branch]
+			trigger := func() {
 				res.Triggered = true
-				res.Logs = append(res.Logs, fmt.Sprintf("git branch %s changed: local %s -> remote %s", branch, shortSHA(localSHA), shortSHA(remoteSHA)))
-			} else {
+				triggered = append(triggered, branch)
+				if target.branch == "" {
+					target.branch, target.sha = branch, remoteSHA
+				}
+			}
+
+			baseSHA, ok := "", false
+			if deployed {
+				baseSHA, ok = prev["refs/heads/"+branch]
+				next["refs/heads/"+branch] = remoteSHA
+			} else if sha, err := getLocalSHA(ctx, g, repoPath, "refs/heads/"+branch); err == nil {
+				baseSHA, ok = sha, true
+			}
+			if !ok {
+				// 本地没有该分支，或尚未记录过部署
+				baseSHA = "missing"
+			}
+
+			switch {
+			case baseSHA == remoteSHA:
 				res.Logs = append(res.Logs, fmt.Sprintf("git branch %s no change", branch))
+				continue
+			case !ok:
+				res.Logs = append(res.Logs, fmt.Sprintf("git new branch %s: remote %s", branch, shortSHA(remoteSHA)))
+			default:
+				res.Logs = append(res.Logs, fmt.Sprintf("git branch %s changed: %s %s -> remote %s", branch, cfg.Compare, shortSHA(baseSHA), shortSHA(remoteSHA)))
+			}
+			filtered := len(cfg.Paths) > 0 || len(cfg.Commits) > 0
+			if !filtered && !cfg.Verify.enabled() {
+				trigger()
+				continue
+			}
+			// 拉取新提交到本地，用于过滤与校验签名
+			if err := fetchRef(ctx, g, repoPath, remoteURL, remoteName, branch); err != nil {
+				if cfg.Verify.enabled() {
+					reject(fmt.Sprintf("git fetch %s error, cannot verify signature: %v", branch, err))
+					restoreState(next, prev, "refs/heads/"+branch)
+					continue
+				}
+				trigger()
+				res.Logs = append(res.Logs, fmt.Sprintf("git fetch %s error, triggering without filters: %v", branch, err))
+				continue
+			}
+			if filtered {
+				if !ok {
+					baseSHA = ""
+				}
+				pass, logs := filterChange(ctx, g, repoPath, branch, baseSHA, remoteSHA, cfg)
+				res.Logs = append(res.Logs, logs...)
+				if !pass {
+					continue
+				}
+			}
+			if cfg.Verify.enabled() {
+				if err := cfg.Verify.verify(ctx, g, repoPath, "commit", remoteSHA); err != nil {
+					reject(fmt.Sprintf("git branch %s rejected: %v", branch, err))
+					restoreState(next, prev, "refs/heads/"+branch)
+					continue
+				}
+				res.Logs = append(res.Logs, fmt.Sprintf("git branch %s head %s signature verified", branch, shortSHA(remoteSHA)))
 			}
+			trigger()
 		}
 	}
 
-	// 6. 检测新标签
-	if cfg.Tags {
+	// 6. 检测新标签及移动的标签
+	if cfg.Tags.Enabled {
 		// 获取远端标签
-		out, err := runGitCmd(netCtx, repoPath, "ls-remote", "--tags", remoteURL)
+		out, err := g.runNet(ctx, repoPath, "ls-remote", "--tags", remoteURL)
 		if err != nil {
-			res.Logs = append(res.Logs, fmt.Sprintf("git ls-remote tags error: %v", err))
-			return res, nil
+			return res, err
+		}
+		remoteTags := make(map[string]string)
+		for tag, sha := range parseRemoteRefs(out, "refs/tags/") {
+			if cfg.Tags.filter.match(tag) {
+				remoteTags[tag] = sha
+			}
 		}
-		remoteTags := parseRemoteRefs(out, "refs/tags/")
 
-		// 获取本地标签
-		localOut, err := runGitCmd(ctx, repoPath, "tag", "--list")
-		if err != nil {
-			res.Logs = append(res.Logs, fmt.Sprintf("git tag list error: %v", err))
-			return res, nil
+		// 获取对比基准的标签集合 (标签名 -> SHA)
+		knownTags := make(map[string]string)
+		if deployed {
+			for k, sha := range prev {
+				if strings.HasPrefix(k, "refs/tags/") {
+					knownTags[strings.TrimPrefix(k, "refs/tags/")] = sha
+				}
+			}
+			for tag, sha := range remoteTags {
+				next["refs/tags/"+tag] = sha
+			}
+		} else {
+			// 与 ls-remote 一致，附注标签取标签对象本身的 SHA
+			localOut, err := g.run(ctx, repoPath, "for-each-ref", "--format=%(objectname) %(refname)", "refs/tags/")
+			if err != nil {
+				return res, err
+			}
+			knownTags = parseRemoteRefs(localOut, "refs/tags/")
 		}
-		localTags := make(map[string]bool)
-		for _, line := range strings.Split(localOut, "\n") {
-			if t := strings.TrimSpace(line); t != "" {
-				localTags[t] = true
+
+		// 对比差异 (Remote - Known)，以及 SHA 变化的已有标签
+		var changed []string
+		for tag, sha := range remoteTags {
+			knownSHA, ok := knownTags[tag]
+			if !ok || (cfg.Tags.Moved && knownSHA != sha) {
+				changed = append(changed, tag)
+			}
+		}
+		sort.Strings(changed)
+
+		// 未通过签名校验的标签不触发，deployed 模式下也不记录
+		if cfg.Verify.enabled() && len(changed) > 0 {
+			if err := fetchTags(ctx, g, repoPath, remoteURL, remoteName, changed); err != nil {
+				reject(fmt.Sprintf("git fetch tags error, cannot verify signatures: %v", err))
+				for _, tag := range changed {
+					restoreState(next, prev, "refs/tags/"+tag)
+				}
+				changed = nil
+			}
+			verified := changed[:0]
+			for _, tag := range changed {
+				kind := "tag"
+				if t, err := g.run(ctx, repoPath, "cat-file", "-t", remoteTags[tag]); err == nil && t == "commit" {
+					// 轻量标签直接指向提交，校验该提交的签名
+					kind = "commit"
+				}
+				if err := cfg.Verify.verify(ctx, g, repoPath, kind, remoteTags[tag]); err != nil {
+					reject(fmt.Sprintf("git tag %s rejected: %v", tag, err))
+					restoreState(next, prev, "refs/tags/"+tag)
+					continue
+				}
+				verified = append(verified, tag)
 			}
+			changed = verified
 		}
 
-		// 对比差异 (Remote - Local)
-		var newTags []string
-		for tag := range remoteTags {
-			if !localTags[tag] {
+		target.tags = changed
+		var newTags, movedTags []string
+		for _, tag := range changed {
+			if knownSHA, ok := knownTags[tag]; ok {
+				movedTags = append(movedTags, fmt.Sprintf("%s (%s -> %s)", tag, shortSHA(knownSHA), shortSHA(remoteTags[tag])))
+			} else {
 				newTags = append(newTags, tag)
 			}
 		}
@@ -133,11 +489,112 @@ func Check(ctx context.Context, cfgDir string, cfg Config) (*Result, error) {
 		} else {
 			res.Logs = append(res.Logs, "git no new tags")
 		}
+		if len(movedTags) > 0 {
+			res.Triggered = true
+			res.Logs = append(res.Logs, fmt.Sprintf("git moved tags: %s", strings.Join(movedTags, ", ")))
+		}
 	}
 
+	// 告知脚本触发的具体分支
+	if len(triggered) > 0 || len(deleted) > 0 {
+		res.Vars = map[string]string{
+			check.EnvName("git", "branches"):         strings.Join(triggered, " "),
+			check.EnvName("git", "deleted_branches"): strings.Join(deleted, " "),
+		}
+		if target.branch != "" {
+			res.Vars[check.EnvName("git", "branch")] = target.branch
+			res.Vars[check.EnvName("git", "sha")] = target.sha
+		}
+	}
+	if cfg.Sync != "" && !target.empty() {
+		res.Actions = append(res.Actions, target.action(g, repoPath, remoteURL, remoteName, cfg.Sync))
+	}
+	if deployed {
+		res.State = next
+	}
 	return res, nil
 }
 
+func (cfg Config) hasBranchPattern() bool {
+	for _, b := range cfg.Branches {
+		if isPattern(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg Config) matchBranch(branch string) bool {
+	for _, p := range cfg.branches {
+		if p.match(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBranches 按配置顺序返回远端存在且匹配的分支，同一条规则匹配的分支按名称排序
+func (cfg Config) expandBranches(remoteHeads map[string]string) []string {
+	var names []string
+	for name := range remoteHeads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []string
+	seen := make(map[string]bool)
+	for _, p := range cfg.branches {
+		for _, name := range names {
+			if !seen[name] && p.match(name) {
+				seen[name] = true
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+// filterChange 按 paths 与 commits 规则判断分支变化是否需要触发，
+// 无法比较时保守地返回 true；需要 head 已拉取到本地
+// base 为空（新分支、尚未记录部署或本地没有该分支）时不过滤 paths，
+// commits 规则只用于 head 提交
+func filterChange(ctx context.Context, g gitCmd, repoPath, branch, base, head string, cfg Config) (bool, []string) {
+	var logs []string
+	if len(cfg.Paths) > 0 && base != "" {
+		files, err := changedPaths(ctx, g, repoPath, base, head, cfg.paths)
+		if err != nil {
+			return true, append(logs, fmt.Sprintf("git diff %s error, triggering without filters: %v", branch, err))
+		}
+		if len(files) == 0 {
+			return false, append(logs, fmt.Sprintf("git branch %s has no changes under paths", branch))
+		}
+		logs = append(logs, fmt.Sprintf("git branch %s changed paths: %s", branch, check.FormatList(files)))
+	}
+	if len(cfg.Commits) > 0 {
+		commits, err := listCommits(ctx, g, repoPath, base, head)
+		if err != nil {
+			return true, append(logs, fmt.Sprintf("git log %s error, triggering without filters: %v", branch, err))
+		}
+		matched := matchCommits(commits, branch, cfg.Commits)
+		if len(matched) == 0 && base == "" {
+			return false, append(logs, fmt.Sprintf("git branch %s head %s does not pass commit rules", branch, shortSHA(head)))
+		}
+		if len(matched) == 0 {
+			return false, append(logs, fmt.Sprintf("git branch %s has no commits passing commit rules (%d new)", branch, len(commits)))
+		}
+		logs = append(logs, fmt.Sprintf("git branch %s commits passing commit rules: %s", branch, formatCommits(matched)))
+	}
+	return true, logs
+}
+
+// restoreState 在 deployed 模式下撤回 ref 的新记录，使其下次仍与上次部署的值对比
+func restoreState(next, prev map[string]string, ref string) {
+	if sha, ok := prev[ref]; ok {
+		next[ref] = sha
+	} else {
+		delete(next, ref)
+	}
+}
+
 // 辅助函数
 
 func findRepoPath(startDir string) (string, error) {
@@ -157,28 +614,6 @@ func findRepoPath(startDir string) (string, error) {
 	}
 }
 
-func runGitCmd(ctx context.Context, dir string, args ...string) (string, error) {
-	cmd := exec.CommandContext(ctx, "git", args...)
-	cmd.Dir = dir
-	// 禁用交互式提示，防止卡死
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("%v, output: %s", err, string(out))
-	}
-	return strings.TrimSpace(string(out)), nil
-}
-
-func buildAuthURL(rawURL, user, pass string) string {
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return rawURL
-	}
-	u.User = url.UserPassword(user, pass)
-	return u.String()
-}
-
 func parseRemoteRefs(output, prefix string) map[string]string {
 	refs := make(map[string]string)
 	lines := strings.Split(output, "\n")
@@ -202,9 +637,9 @@ func parseRemoteRefs(output, prefix string) map[string]string {
 	return refs
 }
 
-func getLocalSHA(ctx context.Context, dir, ref string) (string, error) {
+func getLocalSHA(ctx context.Context, g gitCmd, dir, ref string) (string, error) {
 	// 使用 --verify 确保 ref 存在
-	return runGitCmd(ctx, dir, "rev-parse", "--verify", ref)
+	return g.run(ctx, dir, "rev-parse", "--verify", ref)
 }
 
 func shortSHA(sha string) string {
@@ -0,0 +1,33 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// fetchRef 将远端分支拉取到私有命名空间 refs/dg/<remote>/<branch>，
+// 不影响用户的本地分支与 remote-tracking 分支
+func fetchRef(ctx context.Context, g gitCmd, repoPath, remoteURL, remoteName, branch string) error {
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/dg/%s/%s", branch, remoteName, branch)
+	_, err := g.runNet(ctx, repoPath, "fetch", "--no-tags", "--quiet", remoteURL, refspec)
+	return err
+}
+
+// changedPaths 返回 base 与 head 之间变更且匹配 paths 规则的文件
+func changedPaths(ctx context.Context, g gitCmd, repoPath, base, head string, paths filter) ([]string, error) {
+	if _, err := g.run(ctx, repoPath, "cat-file", "-e", base+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("commit %s not available locally", shortSHA(base))
+	}
+	out, err := g.run(ctx, repoPath, "-c", "core.quotepath=off", "diff", "--name-only", "--no-renames", base, head)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(out, "\n") {
+		if f != "" && paths.match(f) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
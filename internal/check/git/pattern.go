@@ -0,0 +1,93 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dg/internal/check"
+)
+
+// pattern 为一条匹配规则：以 / 包裹时按正则匹配，否则按 glob 匹配（语法见 check.GlobToRegexp）
+type pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func compilePattern(s string) (pattern, error) {
+	return compile(s, "^"+check.GlobToRegexp(s)+"$")
+}
+
+// compileText 用于提交信息等自由文本：非正则的规则按子串匹配
+func compileText(s string) (pattern, error) {
+	return compile(s, regexp.QuoteMeta(s))
+}
+
+func compile(s, expr string) (pattern, error) {
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		expr = s[1 : len(s)-1]
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return pattern{raw: s, re: re}, nil
+}
+
+func (p pattern) match(s string) bool { return p.re.MatchString(s) }
+
+// isPattern 报告 s 是否为 glob 或正则，而非普通名称
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[") || (len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/"))
+}
+
+// filter 为一组 include/exclude 规则；include 为空时视为全部包含
+type filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+func newFilter(include, exclude []string) (filter, error) {
+	return buildFilter(include, exclude, compilePattern)
+}
+
+// newTextFilter 与 newFilter 相同，但非正则的规则按子串匹配
+func newTextFilter(include, exclude []string) (filter, error) {
+	return buildFilter(include, exclude, compileText)
+}
+
+func buildFilter(include, exclude []string, compileFn func(string) (pattern, error)) (filter, error) {
+	var f filter
+	for _, s := range include {
+		p, err := compileFn(s)
+		if err != nil {
+			return f, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, s := range exclude {
+		p, err := compileFn(s)
+		if err != nil {
+			return f, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+func (f filter) match(s string) bool {
+	for _, p := range f.exclude {
+		if p.match(s) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(s) {
+			return true
+		}
+	}
+	return false
+}
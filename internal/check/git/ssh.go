@@ -0,0 +1,72 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SSHConfig 对应 watchs.git.ssh，为 SSH 远端指定独立的部署密钥与 known_hosts：
+//
+//	ssh:
+//	  key_file: ./keys/deploy_ed25519
+//	  known_hosts_file: ./keys/known_hosts
+//	  strict_host_key_checking: yes
+//
+// 相对路径相对 config.yml 所在目录
+type SSHConfig struct {
+	KeyFile        string `yaml:"key_file"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// StrictHostKeyChecking 为 yes、no 或 accept-new，为空时沿用 ssh 默认值
+	StrictHostKeyChecking string `yaml:"strict_host_key_checking"`
+}
+
+// init 解析相对路径，并校验文件存在、选项合法
+func (s *SSHConfig) init(root string) error {
+	for _, p := range []*string{&s.KeyFile, &s.KnownHostsFile} {
+		if *p == "" {
+			continue
+		}
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(root, *p)
+		}
+		if _, err := os.Stat(*p); err != nil {
+			return fmt.Errorf("ssh: %w", err)
+		}
+	}
+	switch s.StrictHostKeyChecking {
+	case "true":
+		s.StrictHostKeyChecking = "yes"
+	case "false":
+		s.StrictHostKeyChecking = "no"
+	case "", "yes", "no", "accept-new":
+	default:
+		return fmt.Errorf("ssh: strict_host_key_checking must be yes, no or accept-new, got %q", s.StrictHostKeyChecking)
+	}
+	return nil
+}
+
+// command 返回用于 GIT_SSH_COMMAND 的命令行，未配置任何选项时返回空串
+func (s SSHConfig) command() string {
+	if s == (SSHConfig{}) {
+		return ""
+	}
+	// BatchMode 避免 ssh 等待口令或确认主机密钥输入
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if s.KeyFile != "" {
+		args = append(args, "-i", shellQuote(s.KeyFile), "-o", "IdentitiesOnly=yes")
+	}
+	if s.KnownHostsFile != "" {
+		args = append(args, "-o", shellQuote("UserKnownHostsFile="+s.KnownHostsFile))
+	}
+	if s.StrictHostKeyChecking != "" {
+		args = append(args, "-o", "StrictHostKeyChecking="+s.StrictHostKeyChecking)
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote 用单引号包裹 s，GIT_SSH_COMMAND 会经过 shell 解析
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,98 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	"dg/internal/check"
+)
+
+// watchs.git.sync 的取值：触发后、执行脚本前由 dg 同步本地仓库
+const (
+	// SyncFetch 只拉取触发的分支与标签，不改动工作区
+	SyncFetch = "fetch"
+	// SyncCheckout 拉取后切换到该分支并快进到远端 SHA，或检出最新的标签；有冲突时失败
+	SyncCheckout = "checkout"
+	// SyncReset 拉取后强制将分支重置到远端 SHA (相当于 reset --hard)，或强制检出最新的标签
+	SyncReset = "reset"
+)
+
+func parseSync(s string) error {
+	switch s {
+	case "", SyncFetch, SyncCheckout, SyncReset:
+		return nil
+	}
+	return fmt.Errorf("sync must be %s, %s or %s, got %q", SyncFetch, SyncCheckout, SyncReset, s)
+}
+
+// syncTarget 为同步的目标：第一个触发的分支，没有分支触发时为触发的标签中最新的一个
+type syncTarget struct {
+	branch string
+	sha    string
+	tags   []string
+}
+
+func (t syncTarget) empty() bool { return t.branch == "" && len(t.tags) == 0 }
+
+// action 返回执行同步的内置动作
+func (t syncTarget) action(g gitCmd, repoPath, remoteURL, remoteName, mode string) check.Action {
+	desc := fmt.Sprintf("git sync (%s): tags %s", mode, check.FormatList(t.tags))
+	if t.branch != "" {
+		desc = fmt.Sprintf("git sync (%s): branch %s -> %s", mode, t.branch, shortSHA(t.sha))
+	}
+	return check.Action{
+		Desc: desc,
+		Run: func(ctx context.Context) error {
+			return t.run(ctx, g, repoPath, remoteURL, remoteName, mode)
+		},
+	}
+}
+
+func (t syncTarget) run(ctx context.Context, g gitCmd, repoPath, remoteURL, remoteName, mode string) error {
+	args := []string{"fetch", "--no-tags", "--quiet", remoteURL}
+	if t.branch != "" {
+		args = append(args, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", t.branch, remoteName, t.branch))
+	} else {
+		for _, tag := range t.tags {
+			args = append(args, fmt.Sprintf("+refs/tags/%s:refs/tags/%s", tag, tag))
+		}
+	}
+	if _, err := g.runNet(ctx, repoPath, args...); err != nil {
+		return err
+	}
+	if mode == SyncFetch {
+		return nil
+	}
+
+	if t.branch == "" {
+		// 按创建时间选出最新的标签
+		refs := make([]string, len(t.tags))
+		for i, tag := range t.tags {
+			refs[i] = "refs/tags/" + tag
+		}
+		newest, err := g.run(ctx, repoPath, append([]string{"for-each-ref", "--sort=-creatordate", "--count=1", "--format=%(refname:short)"}, refs...)...)
+		if err != nil {
+			return err
+		}
+		if mode == SyncReset {
+			_, err = g.run(ctx, repoPath, "checkout", "--quiet", "--force", "--detach", newest)
+		} else {
+			_, err = g.run(ctx, repoPath, "checkout", "--quiet", "--detach", newest)
+		}
+		return err
+	}
+
+	if mode == SyncReset {
+		_, err := g.run(ctx, repoPath, "checkout", "--quiet", "--force", "-B", t.branch, t.sha)
+		return err
+	}
+	if _, err := getLocalSHA(ctx, g, repoPath, "refs/heads/"+t.branch); err != nil {
+		_, err = g.run(ctx, repoPath, "checkout", "--quiet", "-b", t.branch, t.sha)
+		return err
+	}
+	if _, err := g.run(ctx, repoPath, "checkout", "--quiet", t.branch); err != nil {
+		return err
+	}
+	_, err := g.run(ctx, repoPath, "merge", "--ff-only", "--quiet", t.sha)
+	return err
+}
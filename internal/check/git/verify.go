@@ -0,0 +1,69 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// VerifyConfig 对应 watchs.git.verify_signatures，要求新的分支头提交或标签
+// 带有受信任的签名才会触发：
+//
+//	verify_signatures:
+//	  allowed_signers: ./keys/allowed_signers  # SSH 签名，格式同 gpg.ssh.allowedSignersFile
+//	  gnupg_home: ./keys/gnupg                 # GPG 签名，仅信任该 GnuPG 目录中的公钥
+//
+// 只配置其中一项时，另一种签名一律视为不受信任；相对路径相对 config.yml 所在目录
+type VerifyConfig struct {
+	AllowedSigners string `yaml:"allowed_signers"`
+	GnuPGHome      string `yaml:"gnupg_home"`
+}
+
+func (v VerifyConfig) enabled() bool { return v.AllowedSigners != "" || v.GnuPGHome != "" }
+
+// init 解析相对路径并校验文件存在
+func (v *VerifyConfig) init(root string) error {
+	for _, p := range []*string{&v.AllowedSigners, &v.GnuPGHome} {
+		if *p == "" {
+			continue
+		}
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(root, *p)
+		}
+		if _, err := os.Stat(*p); err != nil {
+			return fmt.Errorf("verify_signatures: %w", err)
+		}
+	}
+	return nil
+}
+
+// verify 校验提交 (kind 为 commit) 或标签对象 (kind 为 tag) 的签名
+func (v VerifyConfig) verify(ctx context.Context, g gitCmd, repoPath, kind, object string) error {
+	g.config = append([]string(nil), g.config...)
+	g.env = append([]string(nil), g.env...)
+	if v.AllowedSigners != "" {
+		g.config = append(g.config, "gpg.ssh.allowedSignersFile="+v.AllowedSigners)
+	} else {
+		g.config = append(g.config, "gpg.ssh.allowedSignersFile="+os.DevNull)
+	}
+	if v.GnuPGHome != "" {
+		g.env = append(g.env, "GNUPGHOME="+v.GnuPGHome)
+	} else {
+		g.config = append(g.config, "gpg.openpgp.program=false")
+	}
+	if _, err := g.run(ctx, repoPath, "verify-"+kind, object); err != nil {
+		return fmt.Errorf("%s %s has no trusted signature: %w", kind, shortSHA(object), err)
+	}
+	return nil
+}
+
+// fetchTags 将远端标签拉取到私有命名空间 refs/dg/<remote>/tags/，用于校验签名
+func fetchTags(ctx context.Context, g gitCmd, repoPath, remoteURL, remoteName string, tags []string) error {
+	args := []string{"fetch", "--no-tags", "--quiet", remoteURL}
+	for _, t := range tags {
+		args = append(args, fmt.Sprintf("+refs/tags/%s:refs/dg/%s/tags/%s", t, remoteName, t))
+	}
+	_, err := g.runNet(ctx, repoPath, args...)
+	return err
+}
@@ -0,0 +1,385 @@
+// Package s3 implements watchs.s3: it watches an object, or the objects
+// under a prefix, in an S3-compatible bucket (AWS S3, MinIO, ...) and
+// triggers when their ETags change or objects appear or disappear.
+package s3
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"dg/internal/check"
+)
+
+func init() {
+	check.Register("s3", func(env check.Env) check.Checker {
+		return &checker{root: env.Root, prev: env.State}
+	})
+}
+
+// Config is watchs.s3, or one entry when it is a list:
+//
+//	s3:
+//	  endpoint: https://minio.internal:9000
+//	  path_style: true
+//	  bucket: releases
+//	  key: app/latest.tar.gz
+type Config struct {
+	// Name prefixes log lines and state keys in list form; defaults to s3://bucket/key.
+	Name   string `yaml:"name"`
+	Bucket string `yaml:"bucket"`
+	// Key watches a single object; Prefix watches every object below it.
+	Key    string `yaml:"key"`
+	Prefix string `yaml:"prefix"`
+	// Endpoint defaults to https://s3.<region>.amazonaws.com.
+	Endpoint string `yaml:"endpoint"`
+	// Region defaults to $AWS_REGION, else us-east-1.
+	Region string `yaml:"region"`
+	// PathStyle addresses the bucket as <endpoint>/<bucket> instead of
+	// <bucket>.<endpoint host>; MinIO usually needs it.
+	PathStyle bool `yaml:"path_style"`
+	// AccessKey and SecretKey (inline, from env or from a file) default to
+	// $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY; without them requests
+	// are anonymous.
+	AccessKey       string `yaml:"access_key"`
+	AccessKeyEnv    string `yaml:"access_key_env"`
+	SecretKey       string `yaml:"secret_key"`
+	SecretKeyEnv    string `yaml:"secret_key_env"`
+	SecretKeyFile   string `yaml:"secret_key_file"`
+	SessionTokenEnv string `yaml:"session_token_env"`
+	// Timeout limits each request (default 30s).
+	Timeout time.Duration `yaml:"timeout"`
+	// Insecure skips TLS verification.
+	Insecure bool `yaml:"insecure"`
+	// OnError is fail (default), skip or warn; see check.ParseOnError.
+	OnError string `yaml:"on_error"`
+
+	endpoint *url.URL
+}
+
+// object is what is recorded for a key.
+type object struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// value is the object's entry in state.yml: its ETag, or its modification
+// time when the server returns no ETag.
+func (o object) value() string {
+	if o.ETag != "" {
+		return o.ETag
+	}
+	return o.LastModified.UTC().Format(time.RFC3339)
+}
+
+type checker struct {
+	root    string
+	prev    map[string]string
+	entries []Config
+	// list is true when watchs.s3 is a list; state keys and log lines are
+	// then prefixed with the entry name.
+	list bool
+}
+
+func (c *checker) Name() string { return "s3" }
+
+func (c *checker) Decode(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var cfg Config
+		if err := node.Decode(&cfg); err != nil {
+			return err
+		}
+		if err := cfg.init(c.root); err != nil {
+			return err
+		}
+		c.entries = []Config{cfg}
+		return nil
+	}
+	if err := node.Decode(&c.entries); err != nil {
+		return err
+	}
+	c.list = true
+	seen := make(map[string]bool)
+	for i := range c.entries {
+		cfg := &c.entries[i]
+		if err := cfg.init(c.root); err != nil {
+			return err
+		}
+		if seen[cfg.Name] {
+			return fmt.Errorf("duplicate s3 entry %q; set a distinct name", cfg.Name)
+		}
+		seen[cfg.Name] = true
+	}
+	return nil
+}
+
+func (cfg *Config) init(root string) error {
+	if cfg.Bucket == "" {
+		return errors.New("s3 entry needs bucket")
+	}
+	if (cfg.Key == "") == (cfg.Prefix == "") {
+		return fmt.Errorf("s3 bucket %s: set exactly one of key or prefix", cfg.Bucket)
+	}
+	if cfg.Name == "" {
+		cfg.Name = "s3://" + cfg.Bucket + "/" + cfg.Key + cfg.Prefix
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: endpoint must be an http(s) URL, got %q", cfg.Name, cfg.Endpoint)
+	}
+	cfg.endpoint = u
+	if cfg.AccessKey == "" && cfg.AccessKeyEnv == "" {
+		cfg.AccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	}
+	if cfg.SecretKey == "" && cfg.SecretKeyEnv == "" && cfg.SecretKeyFile == "" {
+		cfg.SecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+	}
+	if cfg.SessionTokenEnv == "" {
+		cfg.SessionTokenEnv = "AWS_SESSION_TOKEN"
+	}
+	if cfg.SecretKeyFile != "" && !filepath.IsAbs(cfg.SecretKeyFile) {
+		cfg.SecretKeyFile = filepath.Join(root, cfg.SecretKeyFile)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.OnError, err = check.ParseOnError(cfg.OnError); err != nil {
+		return fmt.Errorf("%s: %w", cfg.Name, err)
+	}
+	return nil
+}
+
+// credentials resolves the configured keys. The access key is optional:
+// when it is missing the requests are sent anonymously.
+func (cfg Config) credentials() (credentials, error) {
+	var creds credentials
+	if cfg.AccessKey != "" {
+		creds.AccessKey = cfg.AccessKey
+	} else {
+		creds.AccessKey = os.Getenv(cfg.AccessKeyEnv)
+	}
+	if creds.AccessKey == "" {
+		return creds, nil
+	}
+	var err error
+	if creds.SecretKey, err = check.Secret(cfg.SecretKey, cfg.SecretKeyEnv, cfg.SecretKeyFile); err != nil {
+		return creds, err
+	}
+	creds.SessionToken = os.Getenv(cfg.SessionTokenEnv)
+	return creds, nil
+}
+
+func (c *checker) Enabled() bool { return len(c.entries) > 0 }
+
+func (c *checker) Check(ctx context.Context) (*check.Result, error) {
+	entries := make([]check.Entry, len(c.entries))
+	for i, cfg := range c.entries {
+		entries[i] = check.Entry{Name: cfg.Name, OnError: cfg.OnError, Check: cfg.check}
+	}
+	return check.CheckEntries(ctx, "s3", c.list, c.prev, entries)
+}
+
+// check fetches the watched objects and compares them with prev, keyed by
+// object key.
+func (cfg Config) check(ctx context.Context, prev map[string]string) (*check.Result, error) {
+	creds, err := cfg.credentials()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	if cfg.Insecure {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = tr
+	}
+
+	var objects map[string]object
+	if cfg.Key != "" {
+		objects, err = cfg.head(ctx, client, creds)
+	} else {
+		objects, err = cfg.list(ctx, client, creds)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &check.Result{State: make(map[string]string), Vars: make(map[string]string)}
+	var added, changed, removed []string
+	latest, missing := "", false
+	for k, o := range objects {
+		v := o.value()
+		res.State[k] = v
+		last, ok := prev[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case last != v:
+			changed = append(changed, k)
+		}
+		if latest == "" || o.LastModified.After(objects[latest].LastModified) ||
+			(o.LastModified.Equal(objects[latest].LastModified) && k > latest) {
+			latest = k
+		}
+	}
+	for k, v := range prev {
+		if _, ok := objects[k]; ok {
+			continue
+		}
+		if cfg.Key != "" {
+			// a missing watched object is no release to deploy: keep its last
+			// value until it is uploaded again
+			res.State[k] = v
+			res.Logs = append(res.Logs, fmt.Sprintf("s3 %s not found; keeping the last deployed ETag", k))
+			missing = true
+			continue
+		}
+		removed = append(removed, k)
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	for _, l := range []struct {
+		kind string
+		keys []string
+	}{{"added", added}, {"changed", changed}, {"removed", removed}} {
+		if len(l.keys) > 0 {
+			res.Triggered = true
+			res.Logs = append(res.Logs, fmt.Sprintf("s3 %s: %s", l.kind, check.FormatList(l.keys)))
+		}
+		res.Vars[check.EnvName("s3", l.kind)] = strings.Join(l.keys, "\n")
+	}
+	res.Vars[check.EnvName("s3", "latest")] = latest
+	if latest != "" {
+		res.Vars[check.EnvName("s3", "etag")] = objects[latest].ETag
+	}
+	switch {
+	case res.Triggered || missing:
+	case len(objects) == 0:
+		res.Logs = append(res.Logs, "s3 no objects found")
+	default:
+		res.Logs = append(res.Logs, fmt.Sprintf("s3 no change; %d watched", len(objects)))
+	}
+	return res, nil
+}
+
+// head returns the watched object, or nothing when it does not exist.
+func (cfg Config) head(ctx context.Context, client *http.Client, creds credentials) (map[string]object, error) {
+	resp, err := cfg.do(ctx, client, creds, http.MethodHead, cfg.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	objects := make(map[string]object)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return objects, nil
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("HEAD %s: unexpected status %s", cfg.Key, resp.Status)
+	}
+	o := object{ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		o.LastModified, _ = http.ParseTime(lm)
+	}
+	objects[cfg.Key] = o
+	return objects, nil
+}
+
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		ETag         string    `xml:"ETag"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns every object under the prefix, following continuation tokens.
+func (cfg Config) list(ctx context.Context, client *http.Client, creds credentials) (map[string]object, error) {
+	objects := make(map[string]object)
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {cfg.Prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := cfg.do(ctx, client, creds, http.MethodGet, "", q)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("list %s: %s", cfg.Prefix, responseError(resp, body))
+		}
+		var page listResult
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("list %s: decode response: %w", cfg.Prefix, err)
+		}
+		for _, c := range page.Contents {
+			objects[c.Key] = object{ETag: strings.Trim(c.ETag, `"`), LastModified: c.LastModified}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key (the bucket itself when empty).
+func (cfg Config) do(ctx context.Context, client *http.Client, creds credentials, method, key string, query url.Values) (*http.Response, error) {
+	u := *cfg.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if cfg.PathStyle {
+		p += "/" + cfg.Bucket
+	} else {
+		u.Host = cfg.Bucket + "." + u.Host
+	}
+	p += "/" + key
+	u.Path, u.RawPath = p, escape(p, false)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	sign(req, creds, cfg.Region, time.Now())
+	return client.Do(req)
+}
+
+// responseError describes a failed response using the S3 error document
+// when there is one.
+func responseError(resp *http.Response, body []byte) string {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Sprintf("%s: %s (%s)", resp.Status, e.Code, e.Message)
+	}
+	return "unexpected status " + resp.Status
+}
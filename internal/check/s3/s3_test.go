@@ -0,0 +1,217 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves HEAD and ListObjectsV2 for one path-style bucket, pageSize
+// keys per page, and rejects requests whose signature does not verify.
+type fakeS3 struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string]string // key -> ETag
+	pages   int
+}
+
+func (f *fakeS3) put(key, etag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = etag
+}
+
+func (f *fakeS3) remove(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, key)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.verify(r) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>"))
+		return
+	}
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+	switch {
+	case r.Method == http.MethodHead:
+		etag, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		q := r.URL.Query()
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		start, _ := strconv.Atoi(q.Get("continuation-token"))
+		end := start + f.pageSize
+		type content struct {
+			Key          string
+			ETag         string
+			LastModified time.Time
+		}
+		var page struct {
+			XMLName               xml.Name `xml:"ListBucketResult"`
+			Contents              []content
+			IsTruncated           bool
+			NextContinuationToken string `xml:",omitempty"`
+		}
+		if end < len(keys) {
+			page.IsTruncated, page.NextContinuationToken = true, strconv.Itoa(end)
+		} else {
+			end = len(keys)
+		}
+		for _, k := range keys[start:end] {
+			page.Contents = append(page.Contents, content{k, `"` + f.objects[k] + `"`, modified})
+		}
+		f.pages++
+		b, _ := xml.Marshal(page)
+		w.Write(b)
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// verify signs a copy of r with the test keys at its X-Amz-Date and compares
+// the Authorization headers.
+func (f *fakeS3) verify(r *http.Request) bool {
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req := r.Clone(context.Background())
+	req.URL.Host = r.Host
+	req.Header = http.Header{}
+	if v := r.Header.Get("X-Amz-Security-Token"); v != "" {
+		req.Header.Set("X-Amz-Security-Token", v)
+	}
+	sign(req, testCreds, "us-east-1", now)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	f := &fakeS3{t: t, bucket: "releases", pageSize: 2, objects: make(map[string]string)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func testConfig(t *testing.T, srv *httptest.Server, key, prefix string) Config {
+	cfg := Config{
+		Bucket:    "releases",
+		Key:       key,
+		Prefix:    prefix,
+		Endpoint:  srv.URL,
+		PathStyle: true,
+		AccessKey: testCreds.AccessKey,
+		SecretKey: testCreds.SecretKey,
+	}
+	if err := cfg.init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestCheckKey(t *testing.T) {
+	f, srv := newFakeS3(t)
+	cfg := testConfig(t, srv, "app/app 1.0.tar.gz", "")
+	ctx := context.Background()
+	f.put("app/app 1.0.tar.gz", "e1")
+
+	res, err := cfg.check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Triggered || res.State["app/app 1.0.tar.gz"] != "e1" || res.Vars["DG_S3_ETAG"] != "e1" {
+		t.Fatalf("first run: triggered=%v state=%v vars=%v", res.Triggered, res.State, res.Vars)
+	}
+
+	prev := res.State
+	if res, err = cfg.check(ctx, prev); err != nil {
+		t.Fatal(err)
+	}
+	if res.Triggered {
+		t.Fatal("unchanged object triggered")
+	}
+
+	// a deleted key keeps its last ETag and does not trigger
+	f.remove("app/app 1.0.tar.gz")
+	if res, err = cfg.check(ctx, prev); err != nil {
+		t.Fatal(err)
+	}
+	if res.Triggered || res.State["app/app 1.0.tar.gz"] != "e1" {
+		t.Fatalf("missing object: triggered=%v state=%v", res.Triggered, res.State)
+	}
+
+	f.put("app/app 1.0.tar.gz", "e2")
+	if res, err = cfg.check(ctx, prev); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Triggered || res.Vars["DG_S3_CHANGED"] != "app/app 1.0.tar.gz" {
+		t.Fatalf("changed object: triggered=%v vars=%v", res.Triggered, res.Vars)
+	}
+}
+
+func TestCheckPrefix(t *testing.T) {
+	f, srv := newFakeS3(t)
+	cfg := testConfig(t, srv, "", "app/")
+	ctx := context.Background()
+	for _, k := range []string{"app/a", "app/b", "app/c", "app/d", "app/e"} {
+		f.put(k, "1")
+	}
+	f.put("other/x", "1")
+
+	res, err := cfg.check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.pages != 3 {
+		t.Fatalf("listed %d pages, want 3", f.pages)
+	}
+	if len(res.State) != 5 || res.Vars["DG_S3_ADDED"] != "app/a\napp/b\napp/c\napp/d\napp/e" {
+		t.Fatalf("state=%v added=%q", res.State, res.Vars["DG_S3_ADDED"])
+	}
+
+	f.put("app/b", "2")
+	f.remove("app/d")
+	if res, err = cfg.check(ctx, res.State); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Triggered || res.Vars["DG_S3_CHANGED"] != "app/b" || res.Vars["DG_S3_REMOVED"] != "app/d" {
+		t.Fatalf("triggered=%v vars=%v", res.Triggered, res.Vars)
+	}
+}
+
+func TestCheckBadSignature(t *testing.T) {
+	_, srv := newFakeS3(t)
+	cfg := testConfig(t, srv, "", "app/")
+	cfg.SecretKey = "wrong"
+	_, err := cfg.check(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("err = %v, want SignatureDoesNotMatch", err)
+	}
+}
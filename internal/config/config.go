@@ -10,20 +10,9 @@ import (
 )
 
 type Config struct {
-	Cron   string `yaml:"cron"`
-	Watchs struct {
-		Docker struct {
-			Images []string `yaml:"images"`
-		} `yaml:"docker"`
-		Git struct {
-			Remote   string   `yaml:"remote"`
-			Username string   `yaml:"username"`
-			Password string   `yaml:"password"`
-			Branches []string `yaml:"branches"`
-			Tags     bool     `yaml:"tags"`
-		} `yaml:"git"`
-	} `yaml:"watchs"`
-	Scripts []string `yaml:"scripts"`
+	Cron    string               `yaml:"cron"`
+	Watchs  map[string]yaml.Node `yaml:"watchs"`
+	Scripts []string             `yaml:"scripts"`
 	Logs    struct {
 		RetainDays int `yaml:"retain_days"`
 	} `yaml:"logs"`
@@ -56,8 +45,8 @@ func Load(cfgAbs string) (*Config, string, error) {
 			c.Scripts[i] = filepath.Clean(filepath.Join(root, s))
 		}
 	}
-	if len(c.Watchs.Docker.Images) == 0 && !c.Watchs.Git.Tags && len(c.Watchs.Git.Branches) == 0 {
-		return nil, "", errors.New("at least one watch must be configured: docker.images, git.branches, or git.tags")
+	if len(c.Watchs) == 0 {
+		return nil, "", errors.New("at least one watch must be configured under watchs")
 	}
 	return &c, root, nil
 }
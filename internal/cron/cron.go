@@ -9,7 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"dg/internal/config"
+	"dg/internal/run"
 )
 
 func ruleLine(pathToDG, cfgAbs, cronExpr string) string {
@@ -22,8 +22,8 @@ func Install(cfgAbs string) error {
 	if err != nil {
 		return err
 	}
-	// load config to get cron
-	cfg, _, err := config.Load(abs)
+	// load and validate config (including watchs) to get cron
+	cfg, err := run.Validate(abs)
 	if err != nil {
 		return err
 	}
@@ -103,6 +103,10 @@ func Info(l *log.Logger, msg string, args ...interface{}) {
     l.Printf("INFO %s", fmt.Sprintf(msg, args...))
 }
 
+func Warn(l *log.Logger, msg string, args ...interface{}) {
+    l.Printf("WARN %s", fmt.Sprintf(msg, args...))
+}
+
 func Error(l *log.Logger, msg string, args ...interface{}) {
     l.Printf("ERROR %s", fmt.Sprintf(msg, args...))
 }
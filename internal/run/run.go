@@ -2,19 +2,48 @@ package run
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
-	"dg/internal/check/docker"
-	"dg/internal/check/git"
+	"dg/internal/check"
+	_ "dg/internal/check/docker"
+	_ "dg/internal/check/endpoint"
+	_ "dg/internal/check/files"
+	_ "dg/internal/check/git"
+	_ "dg/internal/check/s3"
 	"dg/internal/config"
 	"dg/internal/logger"
 	"dg/internal/scripts"
 	"dg/internal/state"
 )
 
+// Validate loads the config and decodes its watchs section the way Run does,
+// so a config that would fail on every run is rejected up front.
+func Validate(cfgAbs string) (*config.Config, error) {
+	cfg, root, err := config.Load(cfgAbs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := loadCheckers(cfg, root, nil); err != nil {
+		return nil, fmt.Errorf("load watchs: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadCheckers decodes every watch and requires at least one to be enabled.
+func loadCheckers(cfg *config.Config, root string, states map[string]map[string]string) ([]check.Checker, error) {
+	checkers, err := check.Load(cfg.Watchs, root, states)
+	if err == nil && len(checkers) == 0 {
+		err = fmt.Errorf("no watch enabled; configure one of: %s", strings.Join(check.Names(), ", "))
+	}
+	return checkers, err
+}
+
 func Run(cfgAbs string) int {
 	cfg, root, err := config.Load(cfgAbs)
 	if err != nil {
@@ -43,6 +72,7 @@ func Run(cfgAbs string) int {
 	// write current pid immediately after concurrency check
 	st.PID = os.Getpid()
 	st.StartedAt = time.Now().Format(time.RFC3339)
+	st.Errors = nil
 	_ = state.Write(root, st)
 
 	// signal handling
@@ -58,48 +88,73 @@ func Run(cfgAbs string) int {
 	}()
 
 	// checks
+	checkers, err := loadCheckers(cfg, root, st.Watchs)
+	if err != nil {
+		logger.Error(lg.Log, "load watchs: %v", err)
+		st.Errors = []string{fmt.Sprintf("load watchs: %v", err)}
+		st.PID = 0
+		st.FinishedAt = time.Now().Format(time.RFC3339)
+		st.LastResult = "error"
+		_ = state.Write(root, st)
+		return 1
+	}
 	triggered := false
-	if len(cfg.Watchs.Docker.Images) > 0 {
-		res, err := docker.Check(cfg.Watchs.Docker.Images)
+	observed := make(map[string]map[string]string)
+	vars := make(map[string]string)
+	var actions []check.Action
+	var warnings []string
+	for _, c := range checkers {
+		res, err := c.Check(context.Background())
 		if err != nil {
-			logger.Error(lg.Log, "docker check error: %v", err)
+			logger.Error(lg.Log, "%s check error: %v", c.Name(), err)
+			st.Errors = []string{fmt.Sprintf("%s: %v", c.Name(), err)}
 			st.PID = 0
 			st.FinishedAt = time.Now().Format(time.RFC3339)
 			st.LastResult = "error"
 			_ = state.Write(root, st)
 			return 1
 		}
-		if res.Updated {
+		for _, l := range res.Logs {
+			logger.Info(lg.Log, "%s", l)
+		}
+		if res.Triggered {
 			triggered = true
-			for _, d := range res.Details {
-				logger.Info(lg.Log, d)
-			}
 		}
-	}
-	if len(cfg.Watchs.Git.Branches) > 0 || cfg.Watchs.Git.Tags {
-		gitCfg := git.Config{
-			Remote:   cfg.Watchs.Git.Remote,
-			Username: cfg.Watchs.Git.Username,
-			Password: cfg.Watchs.Git.Password,
-			Branches: cfg.Watchs.Git.Branches,
-			Tags:     cfg.Watchs.Git.Tags,
+		if res.State != nil {
+			observed[c.Name()] = res.State
 		}
-		if res, err := git.Check(context.Background(), root, gitCfg); err != nil {
-			logger.Error(lg.Log, "git check error: %v", err)
-		} else {
-			for _, l := range res.Logs {
-				logger.Info(lg.Log, l)
-			}
-			if res.Triggered {
-				triggered = true
-			}
+		for k, v := range res.Vars {
+			vars[k] = v
+		}
+		actions = append(actions, res.Actions...)
+		for _, w := range res.Warnings {
+			logger.Warn(lg.Log, "%s check warning: %s", c.Name(), w)
+			warnings = append(warnings, c.Name()+": "+w)
 		}
 	}
 
 	if triggered {
 		logger.Info(lg.Log, "changes detected; running scripts")
-		if err := scripts.RunSequential(root, cfg.Scripts, lg.File, lg.File); err != nil {
+		for _, a := range actions {
+			logger.Info(lg.Log, "%s", a.Desc)
+			if err := a.Run(context.Background()); err != nil {
+				logger.Error(lg.Log, "%s: %v", a.Desc, err)
+				st.Errors = []string{fmt.Sprintf("%s: %v", a.Desc, err)}
+				st.PID = 0
+				st.FinishedAt = time.Now().Format(time.RFC3339)
+				st.LastResult = "error"
+				_ = state.Write(root, st)
+				return 1
+			}
+		}
+		env := make([]string, 0, len(vars))
+		for k, v := range vars {
+			env = append(env, k+"="+v)
+		}
+		sort.Strings(env)
+		if err := scripts.RunSequential(root, cfg.Scripts, env, lg.File, lg.File); err != nil {
 			logger.Error(lg.Log, "scripts error: %v", err)
+			st.Errors = []string{fmt.Sprintf("scripts: %v", err)}
 			st.PID = 0
 			st.FinishedAt = time.Now().Format(time.RFC3339)
 			st.LastResult = "error"
@@ -110,8 +165,22 @@ func Run(cfgAbs string) int {
 		logger.Info(lg.Log, "no changes; nothing to do")
 	}
 
+	// advance recorded values only after scripts succeeded, so failed deploys are retried
+	for name, values := range observed {
+		if st.Watchs == nil {
+			st.Watchs = make(map[string]map[string]string)
+		}
+		st.Watchs[name] = values
+	}
 	st.PID = 0
 	st.FinishedAt = time.Now().Format(time.RFC3339)
+	// watches tolerated with on_error: warn end the run as a warning (exit code 2)
+	if len(warnings) > 0 {
+		st.LastResult = "warning"
+		st.Errors = warnings
+		_ = state.Write(root, st)
+		return 2
+	}
 	st.LastResult = "success"
 	_ = state.Write(root, st)
 	return 0
@@ -7,7 +7,9 @@ import (
     "path/filepath"
 )
 
-func RunSequential(root string, scripts []string, stdout, stderr *os.File) error {
+// RunSequential runs scripts in order from root; env (KEY=value) is added to
+// the inherited environment.
+func RunSequential(root string, scripts []string, env []string, stdout, stderr *os.File) error {
     for _, s := range scripts {
         if _, err := os.Stat(s); err != nil {
             return fmt.Errorf("script not found: %s", s)
@@ -17,6 +19,7 @@ func RunSequential(root string, scripts []string, stdout, stderr *os.File) error
         }
         cmd := exec.Command(s)
         cmd.Dir = root
+        cmd.Env = append(os.Environ(), env...)
         cmd.Stdout = stdout
         cmd.Stderr = stderr
         if err := cmd.Run(); err != nil {
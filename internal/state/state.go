@@ -15,6 +15,12 @@ type State struct {
     StartedAt  string `yaml:"started_at"`
     FinishedAt string `yaml:"finished_at"`
     LastResult string `yaml:"last_result"`
+    // Errors lists why the last run ended as error or warning, e.g. a watch
+    // that could not be checked; empty after a clean run.
+    Errors []string `yaml:"errors,omitempty"`
+    // Watchs records, per watch type, the values observed by the last
+    // successful run (e.g. deployed git SHAs). Only advanced after scripts succeed.
+    Watchs map[string]map[string]string `yaml:"watchs,omitempty"`
 }
 
 func path(root string) string {